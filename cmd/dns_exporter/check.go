@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Checks are optional assertions on what a correct answer looks like. A
+// zero Checks only requires a NOERROR answer with records.
+type Checks struct {
+	Allow  []netip.Prefix // every address returned must be in one
+	Match  *regexp.Regexp // some record must match
+	MinTTL time.Duration  // no record may have a lower TTL
+	MaxTTL time.Duration  // no record may have a higher TTL
+	Rcode  int            // RCODE the server must answer with
+}
+
+// Check names used for the check label of dns_probe_check_failure_total
+const (
+	checkAllow  = "allow"
+	checkMatch  = "match"
+	checkMinTTL = "min_ttl"
+	checkMaxTTL = "max_ttl"
+	checkRcode  = "rcode"
+)
+
+// setOption applies a host list option. It reports false when key is not
+// a check option.
+func (c *Checks) setOption(key, value string) (bool, error) {
+	switch key {
+	case checkAllow:
+		for _, s := range strings.Split(value, ",") {
+			prefix, err := parsePrefix(strings.TrimSpace(s))
+			if err != nil {
+				return true, fmt.Errorf("invalid allow: %v", err)
+			}
+			c.Allow = append(c.Allow, prefix)
+		}
+	case checkMatch:
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return true, fmt.Errorf("invalid match: %v", err)
+		}
+		c.Match = re
+	case checkMinTTL, checkMaxTTL:
+		ttl, err := parseTTL(value)
+		if err != nil {
+			return true, fmt.Errorf("invalid %s: %v", key, err)
+		}
+		if key == checkMinTTL {
+			c.MinTTL = ttl
+		} else {
+			c.MaxTTL = ttl
+		}
+	case checkRcode:
+		rcode, ok := dns.StringToRcode[strings.ToUpper(value)]
+		if !ok {
+			return true, fmt.Errorf("unknown rcode: %q", value)
+		}
+		c.Rcode = rcode
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// An address or a CIDR; an address allows itself alone.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// A TTL in seconds, or as a duration such as 1h.
+func parseTTL(value string) (time.Duration, error) {
+	if n, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, errors.New("negative TTL")
+	}
+	return d, nil
+}
+
+// Report checks that cannot apply to the site's lookups.
+func (c Checks) validate(site Site) error {
+	if len(c.Allow) > 0 {
+		switch site.Type {
+		case typeHost, "A", "AAAA":
+		default:
+			return fmt.Errorf("allow needs address records, not %s", site.Type)
+		}
+	}
+
+	// net.LookupHost does not report TTLs
+	if (c.MinTTL > 0 || c.MaxTTL > 0) && site.Type == typeHost && len(site.Servers) == 0 {
+		return errors.New("TTL checks need a record type or a server")
+	}
+
+	if c.MaxTTL > 0 && c.MinTTL > c.MaxTTL {
+		return errors.New("min_ttl is above max_ttl")
+	}
+
+	return nil
+}
+
+// Run the checks against a lookup's answer and the RCODE it came with,
+// returning the names of those that failed. An answer with the expected
+// error RCODE has no records to check.
+func (c Checks) Run(answer Answer, rcode int) []string {
+	if rcode != c.Rcode {
+		return []string{checkRcode}
+	}
+	if rcode != dns.RcodeSuccess {
+		return nil
+	}
+
+	var failed []string
+
+	if len(c.Allow) > 0 && slices.ContainsFunc(answer.Values, c.disallowed) {
+		failed = append(failed, checkAllow)
+	}
+
+	if c.Match != nil && !slices.ContainsFunc(answer.Values, c.Match.MatchString) {
+		failed = append(failed, checkMatch)
+	}
+
+	if ttl, ok := answer.minTTL(); ok && c.MinTTL > 0 && time.Duration(ttl)*time.Second < c.MinTTL {
+		failed = append(failed, checkMinTTL)
+	}
+
+	if ttl, ok := answer.maxTTL(); ok && c.MaxTTL > 0 && time.Duration(ttl)*time.Second > c.MaxTTL {
+		failed = append(failed, checkMaxTTL)
+	}
+
+	return failed
+}
+
+// Whether a returned value is not an address in an allowed prefix.
+func (c Checks) disallowed(value string) bool {
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return true
+	}
+
+	addr = addr.Unmap()
+	return !slices.ContainsFunc(c.Allow, func(p netip.Prefix) bool { return p.Contains(addr) })
+}
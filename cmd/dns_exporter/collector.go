@@ -1,25 +1,26 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
-	"net"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/miekg/dns"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 type Site struct {
-	EndPoint string // e.g. https://www.spud.com:5000/api/v2/abc/def/?=xyz
-	Host     string
-	Method   string
-	AuthType string
-	User     string
-	Password string
-	Accept   string
-	Payload  string
+	Host    string   // name looked up, or address for PTR records
+	Type    string   // record type, typeHost for the host's addresses
+	Servers []Server // resolvers queried directly, none means the system resolver
+	Checks  Checks
 }
 
 type SiteStatCollector struct {
@@ -27,35 +28,72 @@ type SiteStatCollector struct {
 	HttpRequestSuccessTotal  *prometheus.CounterVec
 	HttpDuration             *prometheus.GaugeVec
 	HttpDurationBucket       *prometheus.HistogramVec
+	LookupAnswers            *prometheus.GaugeVec
+	LookupAnswerSet          *prometheus.GaugeVec
+	LookupAnswerTTL          *prometheus.GaugeVec
+	SOASerial                *prometheus.GaugeVec
+	ProbeSuccess             *prometheus.GaugeVec
+	CheckFailureTotal        *prometheus.CounterVec
+	LookupFailureTotal       *prometheus.CounterVec
 	siteListFile             string
-	sites                    *[]string
+	sites                    *[]Site
+	timeout                  time.Duration
 }
 
 var siteReloadSignal bool
 
-// Read a tab delimited text file with a header
-// File format - tab separated
+// Read a tab delimited host list, one host per row followed by optional
+// key=value columns:
 //
-//	0           1          2         3         4         5          6
+//	HOST [\t type=TYPE] [\t server=ADDR,...] [\t CHECK=VALUE ...]
 //
-// ENDPOINT \t METHOD \t AUTHTYPE \t USER \t PASSWORD \t ACCEPT \t PAYLOAD
-func loadSites(fileName string) (*[]string, error) {
-	var list []string
+// type is the record type to look up: A, AAAA, CNAME, MX, TXT, SRV, NS,
+// SOA or PTR, where HOST may be an address. Rows without one look up the
+// host's addresses, A and AAAA records, through the system resolver like
+// any program would. Typed rows without a server query the first
+// nameserver in /etc/resolv.conf, and its address is their server label.
+//
+// server names the resolvers to query directly instead of the system
+// resolver, over UDP or, written as tcp://ADDR, over TCP. The port
+// defaults to 53. Rows without one use the -server default, if any.
+//
+// The checks describe a correct answer; a lookup failing one counts in
+// dns_probe_check_failure_total and sets dns_probe_success to 0:
+//
+//	allow=IP|CIDR,...  every address returned is in one of these
+//	match=REGEX        some record matches, e.g. a CNAME target or TXT string
+//	min_ttl=TTL        no record has a lower TTL, in seconds or e.g. 1h
+//	max_ttl=TTL        no record has a higher TTL
+//	rcode=RCODE        the server answers with this RCODE, NOERROR by default
+//
+// Rows with problems are logged and skipped.
+func loadSites(fileName string, servers []Server) (*[]Site, error) {
+	var list []Site
 	if fh, err := os.Open(fileName); err == nil {
 		defer fh.Close()
 
 		reader := csv.NewReader(fh)
 		reader.Comment = '#'
 		reader.Comma = '\t'
+		reader.FieldsPerRecord = -1
 
-		rows, err := reader.ReadAll()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s: %v\n", fileName, err)
-			return nil, err
-		}
+		for {
+			row, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", fileName, err)
+				return nil, err
+			}
 
-		for _, row := range rows {
-			list = append(list, row[0])
+			site, err := parseRow(row, servers)
+			if err != nil {
+				line, _ := reader.FieldPos(0)
+				log.Printf("%s: line %d: skipping: %v\n", fileName, line, err)
+				continue
+			}
+			list = append(list, site)
 		}
 
 	} else {
@@ -66,51 +104,160 @@ func loadSites(fileName string) (*[]string, error) {
 	return &list, nil
 }
 
-func NewSiteStatCollector(siteList string) prometheus.Collector {
+func parseRow(row []string, servers []Server) (Site, error) {
+	site := Site{Host: strings.TrimSpace(row[0]), Type: typeHost, Servers: servers}
+	if site.Host == "" {
+		return site, fmt.Errorf("no host")
+	}
+
+	for _, opt := range row[1:] {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok {
+			return site, fmt.Errorf("option is not key=value: %q", opt)
+		}
+
+		switch strings.ToLower(key) {
+		case "type":
+			t, err := parseType(value)
+			if err != nil {
+				return site, err
+			}
+			site.Type = t
+		case "server":
+			list, err := parseServers(value)
+			if err != nil {
+				return site, err
+			}
+			site.Servers = list
+		default:
+			ok, err := site.Checks.setOption(strings.ToLower(key), value)
+			if err != nil {
+				return site, err
+			}
+			if !ok {
+				return site, fmt.Errorf("unknown option: %s", key)
+			}
+		}
+	}
+
+	return site, site.Checks.validate(site)
+}
+
+// Parse a comma separated list of servers.
+func parseServers(value string) ([]Server, error) {
+	var servers []Server
+	for _, s := range strings.Split(value, ",") {
+		server, err := parseServer(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+func NewSiteStatCollector(fd FlagData) prometheus.Collector {
 	siteReloadSignal = false
 
 	ssc := SiteStatCollector{}
-	ssc.siteListFile = siteList
-	S, err := loadSites(ssc.siteListFile)
+	ssc.siteListFile = fd.SiteList
+	ssc.timeout = fd.Timeout
+	S, err := loadSites(ssc.siteListFile, fd.Servers)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "fatal: %s: %v\n", siteList, err)
+		fmt.Fprintf(os.Stderr, "fatal: %s: %v\n", fd.SiteList, err)
 		os.Exit(2)
 	}
 	ssc.sites = S
 
 	ssc.HttpRequestAttemptsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "dns_lookup_attempt_total",
-		Help: "Total number of DNS A record requests partitioned by site",
+		Help: "Total number of DNS lookups partitioned by site, server and record type",
 	},
-		[]string{"site"},
+		[]string{"site", "server", "type"},
 	)
 
 	ssc.HttpRequestSuccessTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "dns_lookup_success_total",
-		Help: "Total number of DNS A record requests partitioned by site and IP address",
+		Help: "Total number of DNS lookups partitioned by site, server, record type and each IP address returned",
 	},
-		[]string{"site", "ip"},
+		[]string{"site", "server", "type", "ip"},
 	)
 
 	ssc.HttpDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "dns_lookup_duration_seconds",
-		Help: "Duration of DNS A record requests partitioned by site",
+		Help: "Duration of DNS lookups partitioned by site, server and record type",
 	},
-		[]string{"site"},
+		[]string{"site", "server", "type"},
 	)
 
 	ssc.HttpDurationBucket = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "dns_duration_seconds",
-		Help:    "Duration of DNS A record requests partitioned by site",
+		Help:    "Duration of DNS lookups partitioned by site, server and record type",
 		Buckets: []float64{0.001, 0.01, 0.1, 0.25, 0.5, 1, 2, 5},
 	},
-		[]string{"site"},
+		[]string{"site", "server", "type"},
+	)
+
+	ssc.LookupAnswers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dns_lookup_answers",
+		Help: "Number of records the last lookup returned partitioned by site, server and record type",
+	},
+		[]string{"site", "server", "type"},
+	)
+
+	ssc.LookupAnswerSet = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dns_lookup_answer_set_info",
+		Help: "Hash of the sorted records the last lookup returned partitioned by site, server and record type",
+	},
+		[]string{"site", "server", "type", "hash"},
+	)
+
+	ssc.LookupAnswerTTL = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dns_lookup_answer_ttl_seconds",
+		Help: "Lowest TTL among the records the last lookup returned partitioned by site, server and record type",
+	},
+		[]string{"site", "server", "type"},
+	)
+
+	ssc.SOASerial = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dns_soa_serial",
+		Help: "Serial number of the zone's SOA record partitioned by site and server",
+	},
+		[]string{"site", "server"},
+	)
+
+	ssc.ProbeSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dns_probe_success",
+		Help: "Whether the last lookup passed every check (1) or not (0) partitioned by site, server and record type",
+	},
+		[]string{"site", "server", "type"},
+	)
+
+	ssc.CheckFailureTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dns_probe_check_failure_total",
+		Help: "Total number of failed answer checks partitioned by site, server, record type and check",
+	},
+		[]string{"site", "server", "type", "check"},
+	)
+
+	ssc.LookupFailureTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dns_lookup_failure_total",
+		Help: "Total number of failed DNS lookups partitioned by site, server, record type and reason",
+	},
+		[]string{"site", "server", "type", "reason"},
 	)
 
 	prometheus.MustRegister(ssc.HttpRequestAttemptsTotal)
 	prometheus.MustRegister(ssc.HttpRequestSuccessTotal)
 	prometheus.MustRegister(ssc.HttpDuration)
 	prometheus.MustRegister(ssc.HttpDurationBucket)
+	prometheus.MustRegister(ssc.LookupAnswers)
+	prometheus.MustRegister(ssc.LookupAnswerSet)
+	prometheus.MustRegister(ssc.LookupAnswerTTL)
+	prometheus.MustRegister(ssc.SOASerial)
+	prometheus.MustRegister(ssc.ProbeSuccess)
+	prometheus.MustRegister(ssc.CheckFailureTotal)
+	prometheus.MustRegister(ssc.LookupFailureTotal)
 
 	return &ssc
 }
@@ -121,38 +268,13 @@ func (ssc *SiteStatCollector) Describe(ch chan<- *prometheus.Desc) {
 
 func (ssc *SiteStatCollector) Collect(ch chan<- prometheus.Metric) {
 	for _, site := range *ssc.sites {
-
-		if c, err := ssc.HttpRequestAttemptsTotal.GetMetricWithLabelValues(site); err == nil {
-			c.Inc()
-		} else {
-			log.Println(err)
-		}
-
-		start := time.Now()
-		IpList, err := net.LookupHost(site)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
+		if len(site.Servers) == 0 {
+			ssc.lookup(site, nil)
 			continue
 		}
-		duration := time.Since(start).Seconds()
-
-		if c, err := ssc.HttpRequestSuccessTotal.GetMetricWithLabelValues(site, IpList[0]); err == nil {
-			c.Inc()
-		} else {
-			log.Println(err)
-		}
 
-		if d, err := ssc.HttpDuration.GetMetricWithLabelValues(site); err == nil {
-			d.Set(float64(duration))
-		} else {
-			log.Println(err)
-		}
-
-		g, err := ssc.HttpDurationBucket.GetMetricWithLabelValues(site)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
-		} else {
-			g.Observe(float64(duration))
+		for _, server := range site.Servers {
+			ssc.lookup(site, &server)
 		}
 	}
 	/*
@@ -168,3 +290,147 @@ func (ssc *SiteStatCollector) Collect(ch chan<- prometheus.Metric) {
 		}
 	*/
 }
+
+// Look up site on server. Without one, host rows go through the system
+// resolver and typed rows to the first nameserver in resolv.conf, labelled
+// with its address rather than as the system resolver.
+func (ssc *SiteStatCollector) lookup(site Site, server *Server) {
+	var err error
+	if server == nil && site.Type != typeHost {
+		var system Server
+		if system, err = systemServer(); err == nil {
+			server = &system
+		}
+	}
+
+	label := systemResolver
+	if server != nil {
+		label = server.String()
+	}
+
+	if c, err := ssc.HttpRequestAttemptsTotal.GetMetricWithLabelValues(site.Host, label, site.Type); err == nil {
+		c.Inc()
+	} else {
+		log.Println(err)
+	}
+
+	if err != nil {
+		ssc.failed(site, label, err)
+		ssc.setSuccess(site, label, false)
+		return
+	}
+
+	start := time.Now()
+	answer, err := resolve(site.Host, site.Type, server, ssc.timeout)
+	duration := time.Since(start).Seconds()
+
+	// a lookup failing with the RCODE the row expects is a success
+	rcode := dns.RcodeSuccess
+	if err != nil {
+		var ok bool
+		rcode, ok = errorRcode(err)
+		if !ok || rcode != site.Checks.Rcode {
+			ssc.failed(site, label, err)
+			if ok {
+				ssc.checkFailed(site, label, checkRcode)
+			}
+			ssc.setSuccess(site, label, false)
+			return
+		}
+	}
+
+	for _, ip := range answer.addresses() {
+		if c, err := ssc.HttpRequestSuccessTotal.GetMetricWithLabelValues(site.Host, label, site.Type, ip); err == nil {
+			c.Inc()
+		} else {
+			log.Println(err)
+		}
+	}
+	if err == nil {
+		ssc.recordAnswers(site, label, answer)
+	} else {
+		ssc.forgetAnswers(site, label)
+	}
+
+	if d, err := ssc.HttpDuration.GetMetricWithLabelValues(site.Host, label, site.Type); err == nil {
+		d.Set(float64(duration))
+	} else {
+		log.Println(err)
+	}
+
+	g, err := ssc.HttpDurationBucket.GetMetricWithLabelValues(site.Host, label, site.Type)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	} else {
+		g.Observe(float64(duration))
+	}
+
+	failed := site.Checks.Run(answer, rcode)
+	for _, check := range failed {
+		log.Printf("%s: %s: %s: check failed: %s\n", site.Host, label, site.Type, check)
+		ssc.checkFailed(site, label, check)
+	}
+	ssc.setSuccess(site, label, len(failed) == 0)
+}
+
+func (ssc *SiteStatCollector) checkFailed(site Site, server, check string) {
+	if c, err := ssc.CheckFailureTotal.GetMetricWithLabelValues(site.Host, server, site.Type, check); err == nil {
+		c.Inc()
+	} else {
+		log.Println(err)
+	}
+}
+
+func (ssc *SiteStatCollector) setSuccess(site Site, server string, ok bool) {
+	v := 0.0
+	if ok {
+		v = 1
+	}
+
+	if g, err := ssc.ProbeSuccess.GetMetricWithLabelValues(site.Host, server, site.Type); err == nil {
+		g.Set(v)
+	} else {
+		log.Println(err)
+	}
+}
+
+// Export how many records a lookup returned and a hash of the sorted set,
+// which changes only when the set of records does, whatever the order the
+// resolver hands them out in. Also the lowest TTL, when the resolver
+// reports TTLs, and the serial of an SOA record.
+func (ssc *SiteStatCollector) recordAnswers(site Site, server string, answer Answer) {
+	if g, err := ssc.LookupAnswers.GetMetricWithLabelValues(site.Host, server, site.Type); err == nil {
+		g.Set(float64(len(answer.Values)))
+	} else {
+		log.Println(err)
+	}
+
+	sorted := slices.Clone(answer.Values)
+	slices.Sort(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	hash := hex.EncodeToString(sum[:8])
+
+	// keep only the hash of the latest answer set
+	ssc.LookupAnswerSet.DeletePartialMatch(prometheus.Labels{"site": site.Host, "server": server, "type": site.Type})
+	if g, err := ssc.LookupAnswerSet.GetMetricWithLabelValues(site.Host, server, site.Type, hash); err == nil {
+		g.Set(1)
+	} else {
+		log.Println(err)
+	}
+
+	if ttl, ok := answer.minTTL(); ok {
+		if g, err := ssc.LookupAnswerTTL.GetMetricWithLabelValues(site.Host, server, site.Type); err == nil {
+			g.Set(float64(ttl))
+		} else {
+			log.Println(err)
+		}
+	}
+
+	if serial, ok := answer.soaSerial(); ok {
+		if g, err := ssc.SOASerial.GetMetricWithLabelValues(site.Host, server); err == nil {
+			g.Set(float64(serial))
+		} else {
+			log.Println(err)
+		}
+	}
+}
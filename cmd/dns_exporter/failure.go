@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// reason label values of dns_lookup_failure_total besides the lower case
+// RCODE names, nxdomain, servfail, refused and the like
+const (
+	reasonTimeout     = "timeout"
+	reasonTemporary   = "temporary"
+	reasonNoAnswer    = "no_answer"
+	reasonUnreachable = "unreachable"
+	reasonOther       = "other"
+)
+
+// noAnswerError is a NOERROR answer without the records asked for.
+type noAnswerError struct {
+	recordType string
+}
+
+func (e *noAnswerError) Error() string {
+	if e.recordType == typeHost {
+		return "no addresses"
+	}
+	return fmt.Sprintf("no %s records", e.recordType)
+}
+
+// Classify why a lookup failed: the RCODE the server answered with, or for
+// the system resolver, nxdomain for a name it did not find.
+func failureReason(err error) string {
+	if rcode, ok := errorRcode(err); ok {
+		return rcodeName(rcode)
+	}
+
+	var noAnswer *noAnswerError
+	if errors.As(err, &noAnswer) {
+		return reasonNoAnswer
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return reasonTimeout
+	}
+
+	// no server listening, unlike a server answering REFUSED
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EHOSTUNREACH) ||
+		errors.Is(err, syscall.ENETUNREACH) {
+		return reasonUnreachable
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsTemporary {
+		return reasonTemporary
+	}
+
+	return reasonOther
+}
+
+// Count a failed lookup and clear its duration and answer gauges.
+func (ssc *SiteStatCollector) failed(site Site, server string, err error) {
+	reason := failureReason(err)
+	log.Printf("%s: %s: %s: %s: %v\n", site.Host, server, site.Type, reason, err)
+
+	if c, err := ssc.LookupFailureTotal.GetMetricWithLabelValues(site.Host, server, site.Type, reason); err == nil {
+		c.Inc()
+	} else {
+		log.Println(err)
+	}
+
+	ssc.HttpDuration.DeleteLabelValues(site.Host, server, site.Type)
+	ssc.forgetAnswers(site, server)
+}
+
+// Drop the series describing the last answer.
+func (ssc *SiteStatCollector) forgetAnswers(site Site, server string) {
+	ssc.LookupAnswers.DeleteLabelValues(site.Host, server, site.Type)
+	ssc.LookupAnswerSet.DeletePartialMatch(prometheus.Labels{"site": site.Host, "server": server, "type": site.Type})
+	ssc.LookupAnswerTTL.DeleteLabelValues(site.Host, server, site.Type)
+	if site.Type == "SOA" {
+		ssc.SOASerial.DeleteLabelValues(site.Host, server)
+	}
+}
+
+func rcodeName(rcode int) string {
+	if name, ok := dns.RcodeToString[rcode]; ok {
+		return strings.ToLower(name)
+	}
+	return "rcode" + strconv.Itoa(rcode)
+}
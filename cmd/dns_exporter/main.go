@@ -30,7 +30,9 @@ type FlagData struct {
 	LogFh       *os.File
 	LogFileName string
 	Port        string
+	Servers     []Server
 	SiteList    string
+	Timeout     time.Duration
 }
 
 func Version(b bool) {
@@ -51,12 +53,15 @@ func Initialize() FlagData {
 	var ip string
 	var port int
 	var v bool
+	var servers string
 	var fd FlagData
 
 	flag.StringVar(&fd.LogFileName, "log", "/var/log/dns_exporter.log", "Logfile location")
 	flag.StringVar(&ip, "ip", "0.0.0.0", "Server bind IP address")
 	flag.IntVar(&port, "port", 9400, "Server bind port")
 	flag.StringVar(&fd.SiteList, "host-list", "", "Location of site list file")
+	flag.StringVar(&servers, "server", "", "Resolvers to query directly, comma separated, for hosts that name none")
+	flag.DurationVar(&fd.Timeout, "timeout", 5*time.Second, "Lookup timeout")
 	flag.BoolVar(&v, "version", false, "Display the version and exit")
 	flag.Parse()
 
@@ -70,6 +75,18 @@ func Initialize() FlagData {
 		log.Fatal(err)
 	}
 
+	if servers != "" {
+		list, err := parseServers(servers)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fd.Servers = list
+	}
+
+	if fd.Timeout <= 0 {
+		log.Fatalf("timeout must be positive: %v\n", fd.Timeout)
+	}
+
 	if tmp := net.ParseIP(ip); tmp == nil {
 		log.Fatalf("invalid IP address: %s\n", ip)
 	}
@@ -137,7 +154,7 @@ func sigHandler(sigChan chan os.Signal, server *http.Server, fd FlagData) {
 func main() {
 	fd := Initialize()
 
-	ssc := NewSiteStatCollector(fd.SiteList)
+	ssc := NewSiteStatCollector(fd)
 	prometheus.Register(ssc)
 	http.Handle("/metrics", promhttp.Handler())
 
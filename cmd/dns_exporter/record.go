@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// type label of a row without a record type, which looks up the host's
+// addresses, A and AAAA, as net.LookupHost does
+const typeHost = "host"
+
+// Record types a host list row may ask for
+var recordTypes = map[string]uint16{
+	"A":     dns.TypeA,
+	"AAAA":  dns.TypeAAAA,
+	"CNAME": dns.TypeCNAME,
+	"MX":    dns.TypeMX,
+	"TXT":   dns.TypeTXT,
+	"SRV":   dns.TypeSRV,
+	"NS":    dns.TypeNS,
+	"SOA":   dns.TypeSOA,
+	"PTR":   dns.TypePTR,
+}
+
+func parseType(value string) (string, error) {
+	t := strings.ToUpper(value)
+	if _, ok := recordTypes[t]; !ok {
+		return "", fmt.Errorf("unsupported record type: %q", value)
+	}
+	return t, nil
+}
+
+// Answer is what one lookup returned.
+type Answer struct {
+	Values  []string // the data of each record, see recordValue
+	Records []dns.RR // nil when the system resolver answered
+}
+
+// The lowest TTL among the records, which is how long the answer as a
+// whole may be cached.
+func (a Answer) minTTL() (uint32, bool) {
+	if len(a.Records) == 0 {
+		return 0, false
+	}
+
+	ttl := a.Records[0].Header().Ttl
+	for _, rr := range a.Records[1:] {
+		ttl = min(ttl, rr.Header().Ttl)
+	}
+	return ttl, true
+}
+
+// The highest TTL among the records.
+func (a Answer) maxTTL() (uint32, bool) {
+	if len(a.Records) == 0 {
+		return 0, false
+	}
+
+	ttl := a.Records[0].Header().Ttl
+	for _, rr := range a.Records[1:] {
+		ttl = max(ttl, rr.Header().Ttl)
+	}
+	return ttl, true
+}
+
+func (a Answer) soaSerial() (uint32, bool) {
+	for _, rr := range a.Records {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Serial, true
+		}
+	}
+	return 0, false
+}
+
+// The data of a record as one string: the address of A and AAAA, the
+// target of CNAME, NS and PTR, "preference host" of MX, the joined strings
+// of TXT, "priority weight port target" of SRV and "ns mbox serial" of SOA.
+func recordValue(rr dns.RR) string {
+	switch rr := rr.(type) {
+	case *dns.A:
+		return rr.A.String()
+	case *dns.AAAA:
+		return rr.AAAA.String()
+	case *dns.CNAME:
+		return rr.Target
+	case *dns.NS:
+		return rr.Ns
+	case *dns.PTR:
+		return rr.Ptr
+	case *dns.MX:
+		return strconv.Itoa(int(rr.Preference)) + " " + rr.Mx
+	case *dns.TXT:
+		return strings.Join(rr.Txt, "")
+	case *dns.SRV:
+		return fmt.Sprintf("%d %d %d %s", rr.Priority, rr.Weight, rr.Port, rr.Target)
+	case *dns.SOA:
+		return fmt.Sprintf("%s %s %d", rr.Ns, rr.Mbox, rr.Serial)
+	}
+
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+// The name to query: PTR lookups of an address ask for its reverse name.
+func queryName(host, recordType string) string {
+	if recordType == "PTR" && net.ParseIP(host) != nil {
+		name, _ := dns.ReverseAddr(host)
+		return name
+	}
+	return dns.Fqdn(host)
+}
+
+// The ip label values of a successful lookup: each address returned, or a
+// single empty one when the records are not addresses or there are none.
+func (a Answer) addresses() []string {
+	if len(a.Values) == 0 {
+		return []string{""}
+	}
+	for _, rr := range a.Records {
+		switch rr.(type) {
+		case *dns.A, *dns.AAAA:
+		default:
+			return []string{""}
+		}
+	}
+	return a.Values
+}
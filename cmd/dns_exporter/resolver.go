@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// server label of lookups through the system resolver
+const systemResolver = "system"
+
+// Server is a resolver queried directly, over UDP unless given as
+// tcp://address. The port defaults to 53.
+type Server struct {
+	Addr string // host:port
+	Net  string // udp or tcp
+}
+
+func parseServer(s string) (Server, error) {
+	server := Server{Net: "udp"}
+	addr := s
+	if rest, ok := strings.CutPrefix(s, "tcp://"); ok {
+		server.Net = "tcp"
+		addr = rest
+	} else if rest, ok := strings.CutPrefix(s, "udp://"); ok {
+		addr = rest
+	}
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "53")
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	if net.ParseIP(host) == nil {
+		return Server{}, fmt.Errorf("server is not an IP address: %q", s)
+	}
+	server.Addr = addr
+
+	return server, nil
+}
+
+// The server label: the address, prefixed with tcp:// for TCP.
+func (s Server) String() string {
+	if s.Net == "tcp" {
+		return "tcp://" + s.Addr
+	}
+	return s.Addr
+}
+
+// rcodeError is a lookup the server answered with an error RCODE.
+type rcodeError struct {
+	rcode int
+}
+
+func (e *rcodeError) Error() string {
+	return "server answered " + dns.RcodeToString[e.rcode]
+}
+
+// The RCODE a failed lookup stands for: the one the server answered with,
+// or NXDOMAIN for a name the system resolver did not find. Timeouts and
+// other errors have none.
+func errorRcode(err error) (int, bool) {
+	var re *rcodeError
+	if errors.As(err, &re) {
+		return re.rcode, true
+	}
+
+	var de *net.DNSError
+	if errors.As(err, &de) && de.IsNotFound {
+		return dns.RcodeNameError, true
+	}
+
+	return 0, false
+}
+
+// Look up the recordType records of host on the server, an error when
+// there are none. The host type looks up the addresses of host as
+// net.LookupHost does with the system resolver: A and AAAA records.
+func (s Server) lookup(ctx context.Context, host, recordType string) (Answer, error) {
+	qtypes := []uint16{recordTypes[recordType]}
+	if recordType == typeHost {
+		qtypes = []uint16{dns.TypeA, dns.TypeAAAA}
+	}
+
+	var answer Answer
+	for _, qtype := range qtypes {
+		records, err := s.exchange(ctx, queryName(host, recordType), qtype)
+		if err != nil {
+			return Answer{}, err
+		}
+
+		// an answer may lead with the CNAME chain to the records asked for
+		for _, rr := range records {
+			if rr.Header().Rrtype == qtype {
+				answer.Records = append(answer.Records, rr)
+				answer.Values = append(answer.Values, recordValue(rr))
+			}
+		}
+	}
+
+	if len(answer.Records) == 0 {
+		return Answer{}, &noAnswerError{recordType}
+	}
+
+	return answer, nil
+}
+
+// Send one query and return the answer section. A truncated UDP answer is
+// asked for again over TCP.
+func (s Server) exchange(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+
+	client := &dns.Client{Net: s.Net}
+	resp, _, err := client.ExchangeContext(ctx, msg, s.Addr)
+	if err == nil && resp.Truncated && s.Net == "udp" {
+		client.Net = "tcp"
+		resp, _, err = client.ExchangeContext(ctx, msg, s.Addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, &rcodeError{resp.Rcode}
+	}
+
+	return resp.Answer, nil
+}
+
+// The first nameserver in resolv.conf. The system resolver reports no
+// TTLs and knows no record types but addresses and a few others, so typed
+// lookups without a server go to it directly.
+func systemServer() (Server, error) {
+	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return Server{}, err
+	}
+	if len(conf.Servers) == 0 {
+		return Server{}, errors.New("no nameserver in /etc/resolv.conf")
+	}
+
+	return Server{Addr: net.JoinHostPort(conf.Servers[0], conf.Port), Net: "udp"}, nil
+}
+
+// Look up the recordType records of host on server, or the addresses of
+// host through the system resolver when server is nil.
+func resolve(host, recordType string, server *Server, timeout time.Duration) (Answer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if server != nil {
+		return server.lookup(ctx, host, recordType)
+	}
+	if recordType != typeHost {
+		return Answer{}, fmt.Errorf("%s lookup needs a server", recordType)
+	}
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return Answer{}, err
+	}
+	return Answer{Values: addrs}, nil
+}
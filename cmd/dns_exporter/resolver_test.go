@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fakeServer answers queries from zone over UDP and TCP on the same
+// loopback port. Names missing from zone get NXDOMAIN. CNAME records
+// lead every answer, as the chain to the records asked for would.
+type fakeServer struct {
+	zone     map[string][]dns.RR
+	truncate bool // answer UDP queries truncated and empty
+	rcode    int  // answer every query with this RCODE when set
+	addr     string
+}
+
+func startFakeServer(t *testing.T, fs *fakeServer) *fakeServer {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		t.Fatal(err)
+	}
+	fs.addr = pc.LocalAddr().String()
+
+	for _, srv := range []*dns.Server{{PacketConn: pc, Handler: fs}, {Listener: l, Handler: fs}} {
+		started := make(chan struct{})
+		srv.NotifyStartedFunc = func() { close(started) }
+		go srv.ActivateAndServe()
+		<-started
+		t.Cleanup(func() { srv.Shutdown() })
+	}
+
+	return fs
+}
+
+func (fs *fakeServer) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+
+	q := req.Question[0]
+	records, ok := fs.zone[q.Name]
+	switch {
+	case fs.rcode != dns.RcodeSuccess:
+		resp.Rcode = fs.rcode
+	case !ok:
+		resp.Rcode = dns.RcodeNameError
+	case fs.truncate && w.LocalAddr().Network() == "udp":
+		resp.Truncated = true
+	default:
+		for _, rr := range records {
+			if rr.Header().Rrtype == q.Qtype || rr.Header().Rrtype == dns.TypeCNAME {
+				resp.Answer = append(resp.Answer, rr)
+			}
+		}
+	}
+
+	w.WriteMsg(resp)
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func TestParseServer(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Server
+	}{
+		{"192.0.2.1", Server{"192.0.2.1:53", "udp"}},
+		{"192.0.2.1:5353", Server{"192.0.2.1:5353", "udp"}},
+		{"udp://192.0.2.1", Server{"192.0.2.1:53", "udp"}},
+		{"tcp://192.0.2.1", Server{"192.0.2.1:53", "tcp"}},
+		{"2001:db8::1", Server{"[2001:db8::1]:53", "udp"}},
+		{"tcp://[2001:db8::1]:5353", Server{"[2001:db8::1]:5353", "tcp"}},
+	}
+
+	for _, tt := range tests {
+		got, err := parseServer(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseServer(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+
+	if _, err := parseServer("ns.example.com"); err == nil {
+		t.Error("parseServer accepted a host name")
+	}
+}
+
+func TestServerLookupHost(t *testing.T) {
+	zone := map[string][]dns.RR{
+		"www.example.com.": {
+			mustRR(t, "www.example.com. 300 IN A 192.0.2.10"),
+			mustRR(t, "www.example.com. 300 IN A 192.0.2.11"),
+			mustRR(t, "www.example.com. 300 IN AAAA 2001:db8::10"),
+		},
+	}
+	want := []string{"192.0.2.10", "192.0.2.11", "2001:db8::10"}
+
+	for _, tt := range []struct {
+		name     string
+		net      string
+		truncate bool
+	}{
+		{"udp", "udp", false},
+		{"tcp", "tcp", false},
+		{"truncated udp retried over tcp", "udp", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := startFakeServer(t, &fakeServer{zone: zone, truncate: tt.truncate})
+			server := Server{Addr: fs.addr, Net: tt.net}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			answer, err := server.lookup(ctx, "www.example.com", typeHost)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := slices.Sorted(slices.Values(answer.Values))
+			if !slices.Equal(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestServerLookupHostNXDomain(t *testing.T) {
+	fs := startFakeServer(t, &fakeServer{})
+	server := Server{Addr: fs.addr, Net: "udp"}
+
+	_, err := resolve("missing.example.com", typeHost, &server, 2*time.Second)
+	var re *rcodeError
+	if !errors.As(err, &re) || re.rcode != dns.RcodeNameError {
+		t.Fatalf("got %v, want NXDOMAIN", err)
+	}
+}
+
+func TestServerLookupTypes(t *testing.T) {
+	zone := map[string][]dns.RR{
+		"alias.example.com.": {
+			mustRR(t, "alias.example.com. 60 IN CNAME www.example.com."),
+			mustRR(t, "www.example.com. 300 IN A 192.0.2.10"),
+		},
+		"example.com.": {
+			mustRR(t, "example.com. 3600 IN MX 10 mx1.example.com."),
+			mustRR(t, "example.com. 600 IN MX 20 mx2.example.com."),
+			mustRR(t, `example.com. 300 IN TXT "v=spf1 " "-all"`),
+			mustRR(t, "example.com. 900 IN SOA ns1.example.com. admin.example.com. 2024010101 7200 3600 1209600 300"),
+		},
+		"_sip._tcp.example.com.": {
+			mustRR(t, "_sip._tcp.example.com. 300 IN SRV 10 60 5060 sip.example.com."),
+		},
+		"10.2.0.192.in-addr.arpa.": {
+			mustRR(t, "10.2.0.192.in-addr.arpa. 300 IN PTR www.example.com."),
+		},
+	}
+	fs := startFakeServer(t, &fakeServer{zone: zone})
+	server := Server{Addr: fs.addr, Net: "udp"}
+
+	tests := []struct {
+		host, recordType string
+		want             []string
+		ttl              uint32
+	}{
+		{"alias.example.com", "CNAME", []string{"www.example.com."}, 60},
+		{"alias.example.com", "A", []string{"192.0.2.10"}, 300},
+		{"example.com", "MX", []string{"10 mx1.example.com.", "20 mx2.example.com."}, 600},
+		{"example.com", "TXT", []string{"v=spf1 -all"}, 300},
+		{"example.com", "SOA", []string{"ns1.example.com. admin.example.com. 2024010101"}, 900},
+		{"_sip._tcp.example.com", "SRV", []string{"10 60 5060 sip.example.com."}, 300},
+		{"192.0.2.10", "PTR", []string{"www.example.com."}, 300},
+	}
+
+	for _, tt := range tests {
+		answer, err := resolve(tt.host, tt.recordType, &server, 2*time.Second)
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.host, tt.recordType, err)
+			continue
+		}
+		if !slices.Equal(answer.Values, tt.want) {
+			t.Errorf("%s %s: got %q, want %q", tt.host, tt.recordType, answer.Values, tt.want)
+		}
+		if ttl, _ := answer.minTTL(); ttl != tt.ttl {
+			t.Errorf("%s %s: got TTL %d, want %d", tt.host, tt.recordType, ttl, tt.ttl)
+		}
+	}
+
+	answer, err := resolve("example.com", "SOA", &server, 2*time.Second)
+	if serial, ok := answer.soaSerial(); err != nil || !ok || serial != 2024010101 {
+		t.Errorf("got serial %d, %v, want 2024010101", serial, err)
+	}
+
+	if _, err := resolve("example.com", "NS", &server, 2*time.Second); err == nil {
+		t.Error("lookup without NS records succeeded")
+	}
+}
+
+func TestChecks(t *testing.T) {
+	zone := map[string][]dns.RR{
+		"www.example.com.": {
+			mustRR(t, "www.example.com. 300 IN A 192.0.2.10"),
+			mustRR(t, "www.example.com. 30 IN A 198.51.100.1"),
+		},
+		"example.com.": {
+			mustRR(t, `example.com. 300 IN TXT "v=spf1 -all"`),
+		},
+	}
+	fs := startFakeServer(t, &fakeServer{zone: zone})
+	servers := []Server{{Addr: fs.addr, Net: "udp"}}
+
+	tests := []struct {
+		row  []string
+		want []string
+	}{
+		{[]string{"www.example.com", "allow=192.0.2.0/24,198.51.100.1"}, nil},
+		{[]string{"www.example.com", "allow=192.0.2.0/24"}, []string{checkAllow}},
+		{[]string{"www.example.com", "min_ttl=60", "max_ttl=5m"}, []string{checkMinTTL}},
+		{[]string{"www.example.com", "max_ttl=1m"}, []string{checkMaxTTL}},
+		{[]string{"example.com", "type=TXT", "match=^v=spf1 "}, nil},
+		{[]string{"example.com", "type=TXT", "match=^google-site"}, []string{checkMatch}},
+		{[]string{"missing.example.com", "rcode=nxdomain"}, nil},
+		{[]string{"www.example.com", "rcode=NXDOMAIN"}, []string{checkRcode}},
+	}
+
+	for _, tt := range tests {
+		site, err := parseRow(tt.row, servers)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.row, err)
+		}
+
+		answer, err := resolve(site.Host, site.Type, &site.Servers[0], 2*time.Second)
+		rcode := dns.RcodeSuccess
+		if err != nil {
+			var ok bool
+			if rcode, ok = errorRcode(err); !ok {
+				t.Fatalf("%q: %v", tt.row, err)
+			}
+		}
+
+		if got := site.Checks.Run(answer, rcode); !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got failed checks %v, want %v", tt.row, got, tt.want)
+		}
+	}
+}
+
+func TestParseRowChecks(t *testing.T) {
+	for _, row := range [][]string{
+		{"www.example.com", "type=MX", "allow=192.0.2.1"},
+		{"www.example.com", "allow=192.0.2.300"},
+		{"www.example.com", "match=("},
+		{"www.example.com", "min_ttl=1h"}, // the system resolver reports no TTLs
+		{"www.example.com", "type=A", "min_ttl=2h", "max_ttl=1h"},
+		{"www.example.com", "rcode=bogus"},
+	} {
+		if _, err := parseRow(row, nil); err == nil {
+			t.Errorf("parseRow(%q) accepted a bad check", row)
+		}
+	}
+}
+
+func TestFailureReason(t *testing.T) {
+	zone := map[string][]dns.RR{
+		"www.example.com.": {mustRR(t, "www.example.com. 300 IN A 192.0.2.10")},
+	}
+	fs := startFakeServer(t, &fakeServer{zone: zone})
+	broken := startFakeServer(t, &fakeServer{rcode: dns.RcodeServerFailure})
+
+	// a socket that never answers, and a port nothing listens on
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+
+	tests := []struct {
+		host, recordType string
+		server           Server
+		want             string
+	}{
+		{"missing.example.com", typeHost, Server{fs.addr, "udp"}, "nxdomain"},
+		{"www.example.com", "MX", Server{fs.addr, "udp"}, reasonNoAnswer},
+		{"www.example.com", typeHost, Server{broken.addr, "udp"}, "servfail"},
+		{"www.example.com", typeHost, Server{silent.LocalAddr().String(), "udp"}, reasonTimeout},
+		{"www.example.com", typeHost, Server{closed, "tcp"}, reasonUnreachable},
+	}
+
+	for _, tt := range tests {
+		_, err := resolve(tt.host, tt.recordType, &tt.server, 300*time.Millisecond)
+		if got := failureReason(err); got != tt.want {
+			t.Errorf("%s %s on %s: got %s (%v), want %s", tt.host, tt.recordType, tt.server, got, err, tt.want)
+		}
+	}
+}
+
+func TestLoadSitesLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts.tsv")
+	hosts := "# comment\nwww.example.com\n\n# another\nwww.example.com\ttype=BOGUS\n"
+	if err := os.WriteFile(path, []byte(hosts), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	sites, err := loadSites(path, nil)
+	if err != nil || len(*sites) != 1 {
+		t.Fatalf("loadSites: %v, %v", sites, err)
+	}
+	if want := path + ": line 5: skipping"; !strings.Contains(out.String(), want) {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
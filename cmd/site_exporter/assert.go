@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Checks are optional assertions on a probe response. A zero Checks only
+// requires a 2xx status code.
+type Checks struct {
+	Status       []string // exact codes ("204") or classes ("2xx")
+	BodyMatch    *regexp.Regexp
+	BodyNotMatch *regexp.Regexp
+	Headers      []HeaderCheck
+	MaxBody      int64
+	ProtoMajor   int // HTTP major version the site's protocol requires
+}
+
+// HeaderCheck requires a response header, and when Value is set, a header
+// value matching it.
+type HeaderCheck struct {
+	Name  string
+	Value *regexp.Regexp
+}
+
+// Check names used for the check label of http_probe_check_failure_total
+const (
+	checkStatus       = "status"
+	checkBodyMatch    = "body_match"
+	checkBodyNotMatch = "body_not_match"
+	checkHeader       = "header"
+	checkMaxBody      = "max_body"
+)
+
+// ChecksConfig is the site list form of Checks.
+type ChecksConfig struct {
+	Status       []string            `yaml:"status,omitempty" json:"status,omitempty"`
+	BodyMatch    string              `yaml:"body_match,omitempty" json:"body_match,omitempty"`
+	BodyNotMatch string              `yaml:"body_not_match,omitempty" json:"body_not_match,omitempty"`
+	Headers      []HeaderCheckConfig `yaml:"headers,omitempty" json:"headers,omitempty"`
+	MaxBody      int64               `yaml:"max_body,omitempty" json:"max_body,omitempty"`
+}
+
+// HeaderCheckConfig is the site list form of HeaderCheck.
+type HeaderCheckConfig struct {
+	Name  string `yaml:"name" json:"name"`
+	Value string `yaml:"value,omitempty" json:"value,omitempty"`
+}
+
+// setOption applies a tab separated site list option. It reports false
+// when key is not a check option.
+func (c *ChecksConfig) setOption(key, value string) (bool, error) {
+	switch key {
+	case checkStatus:
+		for _, code := range strings.Split(value, ",") {
+			c.Status = append(c.Status, strings.TrimSpace(code))
+		}
+	case checkBodyMatch:
+		c.BodyMatch = value
+	case checkBodyNotMatch:
+		c.BodyNotMatch = value
+	case checkHeader:
+		name, pattern, _ := strings.Cut(value, ":")
+		c.Headers = append(c.Headers, HeaderCheckConfig{
+			Name:  strings.TrimSpace(name),
+			Value: strings.TrimSpace(pattern),
+		})
+	case checkMaxBody:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return true, fmt.Errorf("invalid max_body: %q", value)
+		}
+		c.MaxBody = n
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Validate the configured checks and compile their patterns.
+func (c ChecksConfig) compile() (Checks, []error) {
+	var checks Checks
+	var errs []error
+
+	for _, code := range c.Status {
+		code = strings.ToLower(code)
+		if !validStatus(code) {
+			errs = append(errs, &fieldError{"checks.status", fmt.Errorf("invalid status code: %q", code)})
+			continue
+		}
+		checks.Status = append(checks.Status, code)
+	}
+
+	if c.BodyMatch != "" {
+		re, err := regexp.Compile(c.BodyMatch)
+		if err != nil {
+			errs = append(errs, &fieldError{"checks.body_match", err})
+		}
+		checks.BodyMatch = re
+	}
+
+	if c.BodyNotMatch != "" {
+		re, err := regexp.Compile(c.BodyNotMatch)
+		if err != nil {
+			errs = append(errs, &fieldError{"checks.body_not_match", err})
+		}
+		checks.BodyNotMatch = re
+	}
+
+	for _, h := range c.Headers {
+		if h.Name == "" {
+			errs = append(errs, &fieldError{"checks.headers", errors.New("missing header name")})
+			continue
+		}
+		hc := HeaderCheck{Name: h.Name}
+		if h.Value != "" {
+			re, err := regexp.Compile(h.Value)
+			if err != nil {
+				errs = append(errs, &fieldError{"checks.headers", err})
+				continue
+			}
+			hc.Value = re
+		}
+		checks.Headers = append(checks.Headers, hc)
+	}
+
+	if c.MaxBody < 0 {
+		errs = append(errs, &fieldError{"checks.max_body", fmt.Errorf("must be positive: %d", c.MaxBody)})
+	}
+	checks.MaxBody = c.MaxBody
+
+	return checks, errs
+}
+
+func validStatus(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	if code[0] < '1' || code[0] > '5' {
+		return false
+	}
+	if code[1:] == "xx" {
+		return true
+	}
+	_, err := strconv.Atoi(code)
+	return err == nil
+}
+
+// needsBody reports whether any check looks at the response body.
+func (c *Checks) needsBody() bool {
+	return c.BodyMatch != nil || c.BodyNotMatch != nil || c.MaxBody > 0
+}
+
+// Run returns the names of the checks the response fails. body holds at
+// most MaxBody+1 bytes when MaxBody is set.
+func (c *Checks) Run(resp *http.Response, body []byte) []string {
+	var failed []string
+
+	if !c.statusOK(resp.StatusCode) {
+		failed = append(failed, checkStatus)
+	}
+
+	if c.ProtoMajor != 0 && resp.ProtoMajor != c.ProtoMajor {
+		failed = append(failed, checkProtocol)
+	}
+
+	if c.MaxBody > 0 && int64(len(body)) > c.MaxBody {
+		failed = append(failed, checkMaxBody)
+	}
+
+	if c.BodyMatch != nil && !c.BodyMatch.Match(body) {
+		failed = append(failed, checkBodyMatch)
+	}
+
+	if c.BodyNotMatch != nil && c.BodyNotMatch.Match(body) {
+		failed = append(failed, checkBodyNotMatch)
+	}
+
+	for _, hc := range c.Headers {
+		values := resp.Header.Values(hc.Name)
+		if !headerOK(hc, values) {
+			failed = append(failed, checkHeader)
+			break
+		}
+	}
+
+	return failed
+}
+
+func (c *Checks) statusOK(status int) bool {
+	code := strconv.Itoa(status)
+	if len(c.Status) == 0 {
+		return code[0] == '2'
+	}
+
+	for _, want := range c.Status {
+		if want == code || (strings.HasSuffix(want, "xx") && want[0] == code[0]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func headerOK(hc HeaderCheck, values []string) bool {
+	if len(values) == 0 {
+		return false
+	}
+	if hc.Value == nil {
+		return true
+	}
+
+	for _, v := range values {
+		if hc.Value.MatchString(v) {
+			return true
+		}
+	}
+
+	return false
+}
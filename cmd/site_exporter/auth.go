@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AUTHTYPE values
+const (
+	authNone   = ""
+	authBasic  = "basic"
+	authBearer = "bearer"
+	authHeader = "header"
+	authOAuth2 = "oauth2"
+)
+
+// tokens are refreshed this long before they expire
+const tokenRefreshMargin = time.Minute
+
+// Check the auth settings of a site and set up the token cache for oauth2.
+func (site *Site) initAuth() error {
+	switch site.AuthType {
+	case authNone, "none", authBasic:
+	case authBearer:
+		if site.Password == "" && site.passwordFile == "" {
+			return &fieldError{"auth.password", errors.New("bearer needs the token or @path")}
+		}
+	case authHeader:
+		if site.User == "" {
+			return &fieldError{"auth.user", errors.New("header needs the header name")}
+		}
+	case authOAuth2:
+		if site.TokenURL == "" {
+			return &fieldError{"auth.token_url", errors.New("required for oauth2")}
+		}
+		if _, err := url.Parse(site.TokenURL); err != nil {
+			return &fieldError{"auth.token_url", err}
+		}
+		site.token = &tokenSource{
+			client:       site.tokenClient,
+			url:          site.TokenURL,
+			clientID:     site.User,
+			clientSecret: site.password,
+			scopes:       site.Scopes,
+		}
+	default:
+		return &fieldError{"auth.type", fmt.Errorf("unknown auth type: %q", site.AuthType)}
+	}
+
+	return nil
+}
+
+// Add the site's credentials to req.
+func (site *Site) authorize(ctx context.Context, req *http.Request) error {
+	switch site.AuthType {
+	case authBasic:
+		password, err := site.password()
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(site.User, password)
+	case authBearer:
+		token, err := site.password()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case authHeader:
+		value, err := site.password()
+		if err != nil {
+			return err
+		}
+		req.Header.Set(site.User, value)
+	case authOAuth2:
+		token, err := site.token.get(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return nil
+}
+
+// The site's password. A password of @path is read from the file every
+// time so rotated tokens are picked up.
+func (site *Site) password() (string, error) {
+	if site.passwordFile == "" {
+		return site.Password, nil
+	}
+
+	b, err := os.ReadFile(site.passwordFile)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// tokenSource fetches and caches an OAuth2 client credentials token.
+type tokenSource struct {
+	client       *http.Client
+	url          string
+	clientID     string
+	clientSecret func() (string, error) // read on every fetch, see password
+	scopes       []string
+
+	mu      sync.Mutex
+	token   string
+	refresh time.Time
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Return the cached token, fetching a new one once it is close to expiry.
+func (ts *tokenSource) get(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token != "" && time.Now().Before(ts.refresh) {
+		return ts.token, nil
+	}
+
+	tr, err := ts.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	ts.token = tr.AccessToken
+	if tr.ExpiresIn > 0 {
+		lifetime := time.Duration(tr.ExpiresIn) * time.Second
+		margin := tokenRefreshMargin
+		if margin > lifetime/2 {
+			margin = lifetime / 2
+		}
+		ts.refresh = time.Now().Add(lifetime - margin)
+	} else {
+		// no expiry given, fetch a fresh token on every probe
+		ts.refresh = time.Time{}
+	}
+
+	return ts.token, nil
+}
+
+func (ts *tokenSource) fetch(ctx context.Context) (*tokenResponse, error) {
+	secret, err := ts.clientSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(ts.scopes) > 0 {
+		form.Set("scope", strings.Join(ts.scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ts.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(ts.clientID), url.QueryEscape(secret))
+
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request: %s", resp.Status)
+	}
+
+	var tr tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return nil, fmt.Errorf("token response: %v", err)
+	}
+	if tr.AccessToken == "" {
+		return nil, errors.New("token response: no access_token")
+	}
+	if tr.TokenType != "" && !strings.EqualFold(tr.TokenType, "bearer") {
+		return nil, fmt.Errorf("token response: unsupported token_type %q", tr.TokenType)
+	}
+
+	return &tr, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Parse and validate every site in a site list without probing, writing
+// each problem as file:line:column: message. Returns the number of
+// problems found.
+func checkSiteList(fileName string, w io.Writer) int {
+	cfg, problems, err := readSiteList(fileName)
+	if err != nil {
+		for _, p := range problems {
+			fmt.Fprintf(w, "%s:%v\n", fileName, p)
+		}
+		fmt.Fprintf(w, "%s: %v\n", fileName, err)
+		return len(problems) + 1
+	}
+
+	// the secrets are where the exporter runs, not where the list is checked
+	_, siteErrs := buildSites(cfg.Sites, true)
+	problems = append(problems, siteErrs...)
+
+	_, moduleErrs := buildModules(cfg.Modules, true)
+	for _, errs := range moduleErrs {
+		problems = append(problems, errs...)
+	}
+
+	sortProblems(problems)
+	for _, err := range problems {
+		fmt.Fprintf(w, "%s:%v\n", fileName, err)
+	}
+
+	if len(problems) == 0 {
+		fmt.Fprintf(w, "%s: %d sites, %d modules OK\n", fileName, len(cfg.Sites), len(cfg.Modules))
+	}
+
+	return len(problems)
+}
+
+// Sort problems by where they are in the site list.
+func sortProblems(problems []error) {
+	sort.SliceStable(problems, func(i, j int) bool {
+		a, b := positionOf(problems[i]), positionOf(problems[j])
+		if a.line != b.line {
+			return a.line < b.line
+		}
+		return a.column < b.column
+	})
+}
+
+func positionOf(err error) position {
+	var le *siteListError
+	if errors.As(err, &le) {
+		return position{le.line, le.column}
+	}
+	return position{}
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"strconv"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -16,24 +22,135 @@ import (
 type Site struct {
 	EndPoint string // e.g. https://www.spud.com:5000/api/v2/abc/def/?=xyz
 	Host     string
+	Name     string // the site label of the site's series
 	Method   string
 	AuthType string
 	User     string
 	Password string
 	Accept   string
-	Payload  string
+	Payload  string        // sent as the request body, @path reads it from a file
+	Interval time.Duration // zero means use the -interval default
+	Timeout  time.Duration // zero means use the -timeout default
+
+	ContentType  string
+	Headers      map[string]string // extra request headers
+	Labels       map[string]string // static labels on every series of the site
+	Checks       Checks
+	TokenURL     string   // oauth2 token endpoint
+	Scopes       []string // oauth2 scopes
+	TLS          TLSOptions
+	Proxy        string   // proxy URL, "direct" ignores the proxy environment
+	SourceAddr   net.IP   // local address probes are sent from
+	Interface    string   // network interface probes are sent from
+	MaxRedirects int      // hops a probe follows, 0 stops at the first redirect
+	AllowedHosts []string // hosts redirects may lead to, empty allows any
+	Protocol     string   // http1.1, http2 or http3, empty negotiates
+	Retry        Retry
+	Family       string // ipv4 or ipv6, empty dials either
+	body         []byte
+	passwordFile string // read for every probe, from a password of @path
+	token        *tokenSource
+	client       *http.Client
+	tokenClient  *http.Client
 }
 
 type SiteStatCollector struct {
-	HttpRequestAttemptsTotal *prometheus.CounterVec
-	HttpRequestSuccessTotal  *prometheus.CounterVec
-	HttpDuration             *prometheus.GaugeVec
-	HttpDurationBucket       *prometheus.HistogramVec
-	siteListFile             string
-	sites                    *[]Site
+	ReloadSuccessTotal prometheus.Counter
+	ReloadFailureTotal prometheus.Counter
+	LastReload         prometheus.Gauge
+	scheduler          *Scheduler
+	siteListFile       string
+	timeout            time.Duration
+	reloadMu           sync.Mutex   // serializes Reload
+	mu                 sync.RWMutex // guards sites, modules and metrics
+	sites              *[]Site
+	modules            map[string]Module
+	metrics            *Metrics
+}
+
+// Read the site list. Files ending in .yaml, .yml or .json hold the
+// structured format described in config.go, anything else is the tab
+// separated format read by readTSV. Sites and modules with problems are
+// logged and skipped. A nil list means the file could not be read at all.
+func loadSites(siteList string) (*[]Site, map[string]Module) {
+	cfg, problems, err := readSiteList(siteList)
+	if err != nil {
+		s := fmt.Sprintf("cannot read site list: %s: %v\n", siteList, err)
+		log.Print(s)
+		fmt.Fprint(os.Stderr, s)
+		return nil, nil
+	}
+
+	for _, err := range problems {
+		log.Printf("skipping: %v\n", err)
+	}
+
+	list, siteErrs := buildSites(cfg.Sites, false)
+	for _, err := range siteErrs {
+		log.Printf("skipping: %v\n", err)
+	}
+
+	modules, moduleErrs := buildModules(cfg.Modules, false)
+	for name, errs := range moduleErrs {
+		for _, err := range errs {
+			log.Printf("skipping module %s: %v\n", name, err)
+		}
+	}
+
+	log.Printf("loaded %d sites\n", len(list))
+	return &list, modules
+}
+
+// Build the sites of a site list, leaving out the entries with problems.
+// Two sites with the same name and labels would share their series, so
+// the second is a problem. checkOnly is as for SiteConfig.Site.
+func buildSites(list []SiteConfig, checkOnly bool) ([]Site, []error) {
+	var sites []Site
+	var problems []error
+	for _, sc := range list {
+		built, errs := sc.sites(checkOnly)
+		if len(errs) == 0 {
+			for _, site := range built {
+				if slices.ContainsFunc(sites, site.sameSeries) {
+					err := &fieldError{"name", fmt.Errorf("duplicate site name and labels: %q", site.Name)}
+					errs = append(errs, sc.locate(err))
+					break
+				}
+			}
+		}
+
+		if len(errs) > 0 {
+			problems = append(problems, errs...)
+			continue
+		}
+		sites = append(sites, built...)
+	}
+
+	return sites, problems
 }
 
-var siteReloadSignal bool
+// Read the site list for a reload. Unlike loadSites any problem fails the
+// whole list, so a bad edit never drops sites that are being probed.
+func readSites(siteList string) ([]Site, map[string]Module, error) {
+	cfg, problems, err := readSiteList(siteList)
+	if err != nil {
+		return nil, nil, errors.Join(append(problems, err)...)
+	}
+
+	list, siteErrs := buildSites(cfg.Sites, false)
+	problems = append(problems, siteErrs...)
+
+	modules, moduleErrs := buildModules(cfg.Modules, false)
+	for _, errs := range moduleErrs {
+		problems = append(problems, errs...)
+	}
+
+	if len(problems) > 0 {
+		return nil, nil, errors.Join(problems...)
+	}
+
+	return list, modules, nil
+}
 
 // Read a tab delimited text file with a header
 // File format - tab separated
@@ -41,169 +158,560 @@ var siteReloadSignal bool
 //	0           1          2         3         4         5          6
 //
 // ENDPOINT \t METHOD \t AUTHTYPE \t USER \t PASSWORD \t ACCEPT \t PAYLOAD
-func loadSites(siteList string) *[]Site {
-	var list []Site
-	if fh, err := os.Open(siteList); err == nil {
-		defer fh.Close()
+//
+// Any columns after PAYLOAD are optional key=value settings:
+//
+//	interval=30s                    how often the site is probed
+//	timeout=5s                      deadline for a single probe
+//	content_type=application/json   Content-Type header sent with PAYLOAD
+//	request_header=Name:value       extra request header; repeatable
+//	status=200,204,3xx              allowed status codes, default 2xx
+//	body_match=regex                the body must match regex
+//	body_not_match=regex            the body must not match regex
+//	header=Name:regex               the response must carry header Name, and
+//	                                match regex when one is given; repeatable
+//	max_body=1048576                largest acceptable body in bytes
+//	token_url=https://...           oauth2 token endpoint
+//	scopes=read,write               oauth2 scopes
+//	cert=/path/client.crt           client certificate for mutual TLS
+//	key=/path/client.key            client certificate key
+//	ca=/path/ca-bundle.crt          CA bundle used to verify the server
+//	insecure_skip_verify=true       do not verify the server certificate
+//	server_name=api.internal        SNI and verification name override
+//	proxy=socks5://host:1080        http, https, socks5 or socks5h proxy URL,
+//	                                or "direct"; default HTTP(S)_PROXY and
+//	                                NO_PROXY from the environment
+//	source_address=10.1.2.3         local address probes are sent from
+//	interface=eth1                  interface probes are sent from (linux)
+//	redirects=3                     follow (up to 10 hops, the default), none,
+//	                                or the most hops to follow
+//	allowed_hosts=a.com,*.b.com     hosts redirects may lead to besides the
+//	                                endpoint's own
+//	protocol=http2                  http1.1, http2 (h2c for http endpoints)
+//	                                or http3, the probe fails on any other
+//	name=checkout-health            the site label, see endpointName
+//	label=team:web                  static label on every series of the
+//	                                site, repeatable
+//	retry_attempts=3                tries in all before the probe fails
+//	retry_backoff=200ms             wait before the first retry (500ms),
+//	                                doubled after each
+//	retry_on=reset,timeout,5xx      failure reasons worth a retry, by
+//	                                default refused, reset and timeout
+//	family=ipv6                     probe over ipv4 or ipv6 only, or both
+//	                                to probe over each separately, the
+//	                                series get a family label
+//
+// AUTHTYPE is one of:
+//
+//	basic     USER and PASSWORD sent as basic auth
+//	bearer    PASSWORD is the token, @path reads it from a file on every probe
+//	header    USER is a header name, PASSWORD its value
+//	oauth2    client credentials flow, USER is the client id and PASSWORD
+//	          the client secret, token_url is required
+//
+// USER and PASSWORD may instead reference the secret as env:NAME,
+// file:/path or credential:NAME (a systemd credential), resolved when the
+// list is loaded or reloaded. @path reads the file on every probe.
+func readTSV(r io.Reader) ([]SiteConfig, []error, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = '\t'
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true // JSON payloads carry bare quotes
+
+	// remove the header
+	if _, err := reader.Read(); err != nil {
+		if err == io.EOF {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
 
-		reader := csv.NewReader(fh)
-		reader.Comma = '\t'
-		reader.Read() // remove the header
+	//   0           1          2         3         4         5          6
+	// ENDPOINT \t METHOD \t AUTHTYPE \t USER \t PASSWORD \t ACCEPT \t PAYLOAD
+	columns := []string{"endpoint", "method", "auth.type", "auth.user", "auth.password", "accept", "payload"}
 
-		rows, err := reader.ReadAll()
+	var list []SiteConfig
+	var problems []error
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			s := fmt.Sprintf("cannot read site list: %s\n", siteList)
-			log.Print(s)
-			fmt.Fprint(os.Stderr, s)
-			return &list
+			var pe *csv.ParseError
+			if errors.As(err, &pe) {
+				// the rest of the file cannot be trusted after a quoting error
+				problems = append(problems, &siteListError{line: pe.Line, column: pe.Column, err: pe.Err})
+				break
+			}
+			return nil, nil, err
+		}
+
+		line, column := reader.FieldPos(0)
+
+		// skip comments that do not start in the first column
+		if strings.HasPrefix(strings.TrimSpace(row[0]), "#") {
+			continue
+		}
+		if len(row) < len(columns) {
+			_, last := reader.FieldPos(len(row) - 1)
+			problems = append(problems, &siteListError{
+				line:   line,
+				column: last,
+				err:    fmt.Errorf("expected at least %d columns, got %d", len(columns), len(row)),
+			})
+			continue
+		}
+
+		sc := SiteConfig{
+			EndPoint: row[0],
+			Method:   row[1],
+			Auth: AuthConfig{
+				Type:     row[2],
+				User:     row[3],
+				Password: row[4],
+			},
+			Accept:  row[5],
+			Payload: row[6],
+			line:    line,
+			column:  column,
+			pos:     make(map[string]position),
+		}
+		for i, field := range columns {
+			sc.pos[field] = newPosition(reader.FieldPos(i))
 		}
 
-		for i, row := range rows {
-			// skip comments
-			if row[0] == "#" {
+		for i, opt := range row[len(columns):] {
+			if opt == "" {
 				continue
 			}
-			p, err := url.Parse(row[0])
+
+			optLine, optColumn := reader.FieldPos(len(columns) + i)
+			field, err := sc.setOption(opt)
 			if err != nil {
-				log.Printf("skipping line %d: %v\n", i, err)
+				// keep going so the checker can report the rest of the row
+				sc.problems = append(sc.problems, &siteListError{line: optLine, column: optColumn, err: err})
+				continue
 			}
+			sc.pos[field] = position{optLine, optColumn}
+		}
 
-			//   0           1          2         3         4         5          6
-			// ENDPOINT \t METHOD \t AUTHTYPE \t USER \t PASSWORD \t ACCEPT \t PAYLOAD
-			site := Site{
-				EndPoint: row[0],
-				Host:     p.Host,
-				Method:   row[1],
-				AuthType: row[2],
-				User:     row[3],
-				Password: row[4],
-				Accept:   row[5],
-				Payload:  row[6],
+		list = append(list, sc)
+	}
+
+	return list, problems, nil
+}
+
+// Apply a key=value option column and return the structured name of the
+// field it sets.
+func (sc *SiteConfig) setOption(opt string) (string, error) {
+	key, value, ok := strings.Cut(opt, "=")
+	if !ok {
+		return "", fmt.Errorf("option is not key=value: %q", opt)
+	}
+
+	key = strings.ToLower(key)
+	switch key {
+	case "interval":
+		sc.Interval = value
+	case "timeout":
+		sc.Timeout = value
+	case "content_type":
+		sc.ContentType = value
+	case "proxy":
+		sc.Proxy = value
+	case "source_address":
+		sc.SourceAddr = value
+	case "interface":
+		sc.Interface = value
+	case "redirects":
+		sc.Redirects = value
+	case "allowed_hosts":
+		sc.AllowedHosts = strings.Split(value, ",")
+	case "protocol":
+		sc.Protocol = value
+	case "family":
+		sc.Family = value
+	case "name":
+		sc.Name = value
+	case "label":
+		name, v, ok := strings.Cut(value, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return "", fmt.Errorf("label is not name:value: %q", value)
+		}
+		if sc.Labels == nil {
+			sc.Labels = make(map[string]string)
+		}
+		sc.Labels[strings.TrimSpace(name)] = strings.TrimSpace(v)
+		return "labels", nil
+	case "request_header":
+		name, v, ok := strings.Cut(value, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return "", fmt.Errorf("request_header is not Name:value: %q", value)
+		}
+		if sc.Headers == nil {
+			sc.Headers = make(map[string]string)
+		}
+		sc.Headers[strings.TrimSpace(name)] = strings.TrimSpace(v)
+		return "headers", nil
+	case "token_url":
+		sc.Auth.TokenURL = value
+		return "auth.token_url", nil
+	case "scopes":
+		sc.Auth.Scopes = strings.Split(value, ",")
+		return "auth.scopes", nil
+	default:
+		ok, err := sc.Checks.setOption(key, value)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			if key == checkHeader {
+				return "checks.headers", nil
 			}
+			return "checks." + key, nil
+		}
 
-			list = append(list, site)
+		ok, err = sc.TLS.setOption(key, value)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return "tls." + key, nil
 		}
 
-	} else {
-		s := fmt.Sprintf("cannot open site list: %s\n", siteList)
-		log.Print(s)
-		fmt.Fprint(os.Stderr, s)
-		return nil
+		ok, err = sc.Retry.setOption(key, value)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return "retry." + strings.TrimPrefix(key, "retry_"), nil
+		}
+
+		return "", fmt.Errorf("unknown option: %s", key)
 	}
 
-	log.Printf("loaded %d sites\n", len(list))
-	return &list
+	return key, nil
 }
 
-func NewSiteStatCollector(siteList string) prometheus.Collector {
-	siteReloadSignal = false
+// Build a probe-ready Site from its configuration, reporting every
+// problem found rather than just the first. With checkOnly, for
+// -check-config, secret references are checked but not resolved and the
+// payload and TLS files are not read.
+func (sc SiteConfig) Site(checkOnly bool) (Site, []error) {
+	errs := append([]error(nil), sc.problems...)
 
-	ssc := SiteStatCollector{}
-	ssc.siteListFile = siteList
-	ssc.sites = loadSites(ssc.siteListFile)
-
-	ssc.HttpRequestAttemptsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "http_request_attempt_total",
-		Help: "Total number of requests partitioned by site",
-	},
-		[]string{"site"},
-	)
-
-	ssc.HttpRequestSuccessTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "http_request_success_total",
-		Help: "Total number of requests partitioned by site and HTTP status code",
-	},
-		[]string{"site", "code"},
-	)
-
-	ssc.HttpDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "http_request_duration_seconds",
-		Help: "Duration of HTTP requests",
-	},
-		[]string{"site"},
-	)
-
-	ssc.HttpDurationBucket = prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Name:    "http_request_duration_seconds_bucket",
-		Help:    "Duration of HTTP requests bucketed by histogram and partitioned by site",
-		Buckets: []float64{0.001, 0.01, 0.1, 0.25, 0.5, 1, 2, 5},
-	},
-		[]string{"site"},
-	)
-
-	prometheus.MustRegister(ssc.HttpRequestAttemptsTotal)
-	prometheus.MustRegister(ssc.HttpRequestSuccessTotal)
-	prometheus.MustRegister(ssc.HttpDuration)
-	prometheus.MustRegister(ssc.HttpDurationBucket)
+	p, err := parseEndpoint(sc.EndPoint)
+	if err != nil {
+		errs = append(errs, &fieldError{"endpoint", err})
+	}
 
-	return &ssc
-}
+	if !validMethod(sc.Method) {
+		errs = append(errs, &fieldError{"method", fmt.Errorf("unknown method: %q", sc.Method)})
+	}
 
-func (ssc *SiteStatCollector) Describe(ch chan<- *prometheus.Desc) {
-	prometheus.DescribeByCollect(ssc, ch)
-}
+	site := Site{
+		Name:         sc.Name,
+		EndPoint:     sc.EndPoint,
+		Method:       sc.Method,
+		AuthType:     sc.Auth.Type,
+		User:         sc.Auth.User,
+		Password:     sc.Auth.Password,
+		Accept:       sc.Accept,
+		Payload:      sc.Payload,
+		ContentType:  sc.ContentType,
+		Headers:      sc.Headers,
+		Labels:       sc.Labels,
+		TokenURL:     sc.Auth.TokenURL,
+		Scopes:       sc.Auth.Scopes,
+		TLS:          sc.TLS,
+		Proxy:        sc.Proxy,
+		Interface:    sc.Interface,
+		AllowedHosts: sc.AllowedHosts,
+	}
+	if p != nil {
+		site.Host = p.Host
+		if site.Name == "" {
+			site.Name = endpointName(sc.Method, p)
+		}
+	}
 
-func (ssc *SiteStatCollector) Collect(ch chan<- prometheus.Metric) {
-	for _, site := range *ssc.sites {
+	for name := range sc.Labels {
+		if err := validLabelName(name); err != nil {
+			errs = append(errs, &fieldError{"labels", err})
+		}
+	}
 
-		if c, err := ssc.HttpRequestAttemptsTotal.GetMetricWithLabelValues(site.Host); err == nil {
-			c.Inc()
-		} else {
-			log.Println(err)
+	// both is split up by SiteConfig.sites, alone it means either
+	if family, err := parseFamily(sc.Family); err != nil {
+		errs = append(errs, &fieldError{"family", err})
+	} else if family != familyBoth {
+		site.Family = family
+		site.labelFamily()
+	}
+
+	if sc.Interval != "" {
+		d, err := time.ParseDuration(sc.Interval)
+		if err != nil || d <= 0 {
+			errs = append(errs, &fieldError{"interval", fmt.Errorf("invalid duration: %q", sc.Interval)})
 		}
+		site.Interval = d
+	}
 
-		req, err := http.NewRequest(site.Method, site.EndPoint, nil)
-		if err != nil {
-			log.Println(err)
-			continue
+	if sc.Timeout != "" {
+		d, err := time.ParseDuration(sc.Timeout)
+		if err != nil || d <= 0 {
+			errs = append(errs, &fieldError{"timeout", fmt.Errorf("invalid duration: %q", sc.Timeout)})
 		}
-		req.Header.Add(`Accept`, site.Accept)
-		if site.AuthType == "basic" {
-			req.SetBasicAuth(site.User, site.Password)
+		site.Timeout = d
+	}
+
+	if sc.SourceAddr != "" {
+		if site.SourceAddr = net.ParseIP(sc.SourceAddr); site.SourceAddr == nil {
+			errs = append(errs, &fieldError{"source_address", fmt.Errorf("invalid IP address: %q", sc.SourceAddr)})
 		}
+	}
 
-		client := http.Client{
-			Timeout: time.Duration(1) * time.Minute,
+	if sc.Proxy != "" && sc.Proxy != proxyDirect {
+		if err := validProxy(sc.Proxy); err != nil {
+			errs = append(errs, &fieldError{"proxy", err})
 		}
+	}
 
-		start := time.Now()
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Println(err)
-			continue
+	if n, err := parseRedirects(sc.Redirects); err != nil {
+		errs = append(errs, &fieldError{"redirects", err})
+	} else {
+		site.MaxRedirects = n
+	}
+
+	retry, retryErrs := sc.Retry.compile()
+	site.Retry = retry
+	errs = append(errs, retryErrs...)
+
+	checks, checkErrs := sc.Checks.compile()
+	site.Checks = checks
+	errs = append(errs, checkErrs...)
+
+	if protocol, major, err := parseProtocol(sc.Protocol); err != nil {
+		errs = append(errs, &fieldError{"protocol", err})
+	} else {
+		site.Protocol = protocol
+		site.Checks.ProtoMajor = major
+	}
+	if site.Protocol == protocolHTTP3 {
+		if p != nil && p.Scheme != "https" {
+			errs = append(errs, &fieldError{"protocol", errors.New("http3 needs an https endpoint")})
+		}
+		if sc.Proxy != "" && sc.Proxy != proxyDirect {
+			errs = append(errs, &fieldError{"protocol", errors.New("http3 can not go through a proxy")})
 		}
-		duration := time.Since(start).Seconds()
-		statusCode := strconv.Itoa(resp.StatusCode)
-		resp.Body.Close()
+	}
 
-		if d, err := ssc.HttpDuration.GetMetricWithLabelValues(site.Host); err == nil {
-			d.Set(float64(duration))
-		} else {
-			log.Println(err)
+	if checkOnly {
+		if site.Payload == "@" {
+			errs = append(errs, &fieldError{"payload", errors.New("no file named")})
+		}
+		if err := site.TLS.check(); err != nil {
+			errs = append(errs, err)
 		}
+	} else {
+		if err := site.loadPayload(); err != nil {
+			errs = append(errs, &fieldError{"payload", err})
+		}
+		if err := site.initClient(); err != nil {
+			errs = append(errs, err)
+		}
+	}
 
-		if c, err := ssc.HttpRequestSuccessTotal.GetMetricWithLabelValues(site.Host, statusCode); err == nil {
-			c.Inc()
-		} else {
-			log.Println(err)
+	errs = append(errs, site.resolveSecrets(checkOnly)...)
+
+	if err := site.initAuth(); err != nil {
+		errs = append(errs, err)
+	}
+
+	for i, err := range errs {
+		errs[i] = sc.locate(err)
+	}
+
+	return site, errs
+}
+
+// parseEndpoint parses an endpoint, which must be an http or https URL.
+func parseEndpoint(endpoint string) (*url.URL, error) {
+	p, err := url.Parse(endpoint)
+	switch {
+	case err != nil:
+		return nil, err
+	case p.Scheme != "http" && p.Scheme != "https":
+		return nil, fmt.Errorf("scheme must be http or https: %q", endpoint)
+	case p.Host == "":
+		return nil, fmt.Errorf("no host: %q", endpoint)
+	}
+	return p, nil
+}
+
+// The name of a site without one: its endpoint's host, followed by the
+// path and query when there is more to it than /, and led by the method
+// when it is not GET. It depends on the site alone, so adding or removing
+// other sites never renames it.
+func endpointName(method string, p *url.URL) string {
+	name := p.Host
+	if (p.Path != "" && p.Path != "/") || p.RawQuery != "" {
+		name += p.EscapedPath()
+		if p.RawQuery != "" {
+			name += "?" + p.RawQuery
 		}
+	}
+	if method != "" && method != http.MethodGet {
+		name = method + " " + name
+	}
+	return name
+}
+
+// validMethod reports whether method is one the probe can send. An empty
+// method means GET.
+func validMethod(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+
+	return false
+}
 
-		g, err := ssc.HttpDurationBucket.GetMetricWithLabelValues(site.Host)
+// Resolve PAYLOAD into the request body. A leading @ names a file to read,
+// anything else is sent as-is.
+func (site *Site) loadPayload() error {
+	if strings.HasPrefix(site.Payload, "@") {
+		b, err := os.ReadFile(site.Payload[1:])
 		if err != nil {
-			log.Println(err)
-		} else {
-			g.Observe(float64(duration))
+			return err
 		}
+		site.body = b
+		return nil
 	}
 
-	if siteReloadSignal {
-		newLoad := loadSites(ssc.siteListFile)
-		if newLoad != nil {
-			ssc.sites = newLoad
-			log.Printf("success: loaded %d sites\n", len(*newLoad))
+	site.body = []byte(site.Payload)
+	return nil
+}
+
+func NewSiteStatCollector(fd FlagData) *SiteStatCollector {
+	ssc := SiteStatCollector{}
+	ssc.siteListFile = fd.SiteList
+	ssc.timeout = fd.Timeout
+	ssc.sites, ssc.modules = loadSites(ssc.siteListFile)
+	if ssc.sites == nil {
+		ssc.sites = &[]Site{}
+	}
+
+	ssc.metrics = NewMetrics(siteLabelNames(*ssc.sites))
+
+	ssc.ReloadSuccessTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "site_list_reload_success_total",
+		Help: "Total number of successful site list reloads",
+	})
+
+	ssc.ReloadFailureTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "site_list_reload_failure_total",
+		Help: "Total number of site list reloads rejected because the list was invalid",
+	})
+
+	ssc.LastReload = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "site_list_last_reload_success_timestamp_seconds",
+		Help: "Time of the last successful site list load in unixtime",
+	})
+	ssc.LastReload.SetToCurrentTime()
+
+	prometheus.MustRegister(ssc.ReloadSuccessTotal)
+	prometheus.MustRegister(ssc.ReloadFailureTotal)
+	prometheus.MustRegister(ssc.LastReload)
+
+	ssc.scheduler = NewScheduler(ssc.probe, fd.Concurrency, fd.Interval, fd.Timeout)
+	ssc.scheduler.Start(*ssc.sites)
+
+	return &ssc
+}
+
+// Describe sends nothing, which makes the collector unchecked. The label
+// names of the probe metrics follow the site list, so they may change with
+// a reload.
+func (ssc *SiteStatCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Probes run in the background on each site's interval, so a scrape only
+// reports the cached results and how old they are.
+func (ssc *SiteStatCollector) Collect(ch chan<- prometheus.Metric) {
+	ssc.mu.RLock()
+	sites := *ssc.sites
+	m := ssc.metrics
+	ssc.mu.RUnlock()
+
+	now := time.Now()
+	for _, site := range sites {
+		last, ok := ssc.scheduler.LastProbe(site)
+		if !ok {
+			continue
+		}
+
+		if g, err := m.HttpProbeAge.GetMetricWithLabelValues(m.values(site)...); err == nil {
+			g.Set(now.Sub(last).Seconds())
 		} else {
-			log.Printf("fail: received nil list \n")
+			log.Println(err)
 		}
-		siteReloadSignal = false
 	}
+
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// Probe a site into the current metrics.
+func (ssc *SiteStatCollector) probe(ctx context.Context, site Site) {
+	ssc.mu.RLock()
+	m := ssc.metrics
+	ssc.mu.RUnlock()
+
+	m.probe(ctx, site)
+}
+
+// Reload the site list. The new list replaces the current one only when
+// every site in it is valid, otherwise the current list keeps running.
+func (ssc *SiteStatCollector) Reload() error {
+	ssc.reloadMu.Lock()
+	defer ssc.reloadMu.Unlock()
+
+	sites, modules, err := readSites(ssc.siteListFile)
+	if err != nil {
+		ssc.ReloadFailureTotal.Inc()
+		log.Printf("fail: keeping current site list: %s: %v\n", ssc.siteListFile, err)
+		return err
+	}
+
+	// probes of the old list must not put back the series forgotten below
+	ssc.scheduler.Stop()
+
+	// new label names need new metrics, which starts the counters over.
+	// Otherwise the series of sites that are gone or relabeled are dropped.
+	labelNames := siteLabelNames(sites)
+	ssc.mu.Lock()
+	old := *ssc.sites
+	ssc.sites = &sites
+	ssc.modules = modules
+	if !slices.Equal(labelNames, ssc.metrics.labelNames) {
+		ssc.metrics = NewMetrics(labelNames)
+	} else {
+		for _, site := range old {
+			if !slices.ContainsFunc(sites, site.sameSeries) {
+				ssc.metrics.forget(site)
+			}
+		}
+	}
+	ssc.mu.Unlock()
+	ssc.scheduler.Start(sites)
+
+	ssc.ReloadSuccessTotal.Inc()
+	ssc.LastReload.SetToCurrentTime()
+	log.Printf("success: loaded %d sites\n", len(sites))
+	return nil
 }
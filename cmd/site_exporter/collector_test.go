@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestEndpointName(t *testing.T) {
+	tests := []struct {
+		method, endpoint string
+		want             string
+	}{
+		{"", "https://example.com", "example.com"},
+		{"GET", "https://example.com/", "example.com"},
+		{"", "https://example.com:8443/", "example.com:8443"},
+		{"", "https://example.com/health", "example.com/health"},
+		{"", "https://example.com/?v=2", "example.com/?v=2"},
+		{"", "https://example.com/a%20b?x=1&y=2", "example.com/a%20b?x=1&y=2"},
+		{"HEAD", "https://example.com/health", "HEAD example.com/health"},
+		{"POST", "https://example.com/", "POST example.com"},
+	}
+
+	for _, tt := range tests {
+		p, err := url.Parse(tt.endpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := endpointName(tt.method, p); got != tt.want {
+			t.Errorf("endpointName(%q, %q) = %q, want %q", tt.method, tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+func TestBuildSites(t *testing.T) {
+	tests := []struct {
+		name     string
+		list     []SiteConfig
+		want     []string // names of the sites built
+		problems int
+	}{
+		{"unnamed", []SiteConfig{
+			{EndPoint: "https://example.com/"},
+			{EndPoint: "https://example.com/health"},
+			{EndPoint: "https://example.com/health", Method: "HEAD"},
+		}, []string{"example.com", "example.com/health", "HEAD example.com/health"}, 0},
+		{"same endpoint", []SiteConfig{
+			{EndPoint: "https://example.com/health"},
+			{EndPoint: "https://example.com/health"},
+		}, []string{"example.com/health"}, 1},
+		{"same name", []SiteConfig{
+			{Name: "api", EndPoint: "https://a.example.com/"},
+			{Name: "api", EndPoint: "https://b.example.com/"},
+		}, []string{"api"}, 1},
+		{"same name other labels", []SiteConfig{
+			{Name: "api", EndPoint: "https://a.example.com/", Labels: map[string]string{"env": "prod"}},
+			{Name: "api", EndPoint: "https://b.example.com/", Labels: map[string]string{"env": "stage"}},
+		}, []string{"api", "api"}, 0},
+		{"name of an unnamed site", []SiteConfig{
+			{EndPoint: "https://example.com/"},
+			{Name: "example.com", EndPoint: "https://www.example.com/"},
+		}, []string{"example.com"}, 1},
+		{"both families", []SiteConfig{
+			{EndPoint: "https://example.com/", Family: "both"},
+			{EndPoint: "https://example.com/"},
+		}, []string{"example.com", "example.com", "example.com"}, 0},
+		{"bad endpoints", []SiteConfig{
+			{EndPoint: "ftp://example.com/"},
+			{EndPoint: "example.com"},
+		}, nil, 2},
+	}
+
+	for _, tt := range tests {
+		sites, problems := buildSites(tt.list, false)
+		var names []string
+		for _, site := range sites {
+			names = append(names, site.Name)
+		}
+		if !slices.Equal(names, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, names, tt.want)
+		}
+		if len(problems) != tt.problems {
+			t.Errorf("%s: got problems %v, want %d", tt.name, problems, tt.problems)
+		}
+	}
+}
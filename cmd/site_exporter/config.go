@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the structured site list, read from YAML or JSON and picked by
+// file extension. For example, in YAML:
+//
+//	sites:
+//	  - name: api-health
+//	    endpoint: https://api.example.com/v2/health
+//	    method: POST
+//	    accept: application/json
+//	    payload: '{"ping": true}'
+//	    content_type: application/json
+//	    interval: 30s
+//	    timeout: 5s
+//	    headers:
+//	      X-Request-Source: site_exporter
+//	    labels:
+//	      team: web
+//	      env: prod
+//	    proxy: socks5://bastion.example.com:1080
+//	    source_address: 10.1.2.3
+//	    redirects: 3
+//	    allowed_hosts: [api.example.com, '*.cdn.example.com']
+//	    protocol: http2
+//	    family: both
+//	    retry:
+//	      attempts: 3
+//	      backoff: 200ms
+//	      on: [reset, timeout, 5xx]
+//	    auth:
+//	      type: oauth2
+//	      user: client-id
+//	      password: env:API_CLIENT_SECRET
+//	      token_url: https://auth.example.com/token
+//	      scopes: [health]
+//	    checks:
+//	      status: [200, 204]
+//	      body_match: '"status":\s*"ok"'
+//	      headers:
+//	        - name: Content-Type
+//	          value: application/json
+//	      max_body: 65536
+//	    tls:
+//	      ca: /etc/pki/internal-ca.crt
+//
+// Every field means the same as its counterpart in the tab separated
+// format, see readTSV.
+//
+// modules are named probe settings for the /probe endpoint. A module takes
+// the same fields as a site except endpoint, which comes from the target
+// of each request, and interval and labels, which do not apply:
+//
+//	modules:
+//	  json_api:
+//	    accept: application/json
+//	    auth:
+//	      type: bearer
+//	      password: '@/run/secrets/api-token'
+//	    checks:
+//	      body_match: '"status":\s*"ok"'
+type Config struct {
+	Sites   []SiteConfig          `yaml:"sites" json:"sites"`
+	Modules map[string]SiteConfig `yaml:"modules,omitempty" json:"modules,omitempty"`
+}
+
+// SiteConfig is one site as written in a site list, before files are read
+// and patterns compiled.
+type SiteConfig struct {
+	Name         string            `yaml:"name,omitempty" json:"name,omitempty"`
+	EndPoint     string            `yaml:"endpoint" json:"endpoint"`
+	Method       string            `yaml:"method,omitempty" json:"method,omitempty"`
+	Accept       string            `yaml:"accept,omitempty" json:"accept,omitempty"`
+	Payload      string            `yaml:"payload,omitempty" json:"payload,omitempty"`
+	ContentType  string            `yaml:"content_type,omitempty" json:"content_type,omitempty"`
+	Interval     string            `yaml:"interval,omitempty" json:"interval,omitempty"`
+	Timeout      string            `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	Headers      map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	Labels       map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	Proxy        string            `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+	SourceAddr   string            `yaml:"source_address,omitempty" json:"source_address,omitempty"`
+	Interface    string            `yaml:"interface,omitempty" json:"interface,omitempty"`
+	Redirects    string            `yaml:"redirects,omitempty" json:"redirects,omitempty"`
+	AllowedHosts []string          `yaml:"allowed_hosts,omitempty" json:"allowed_hosts,omitempty"`
+	Protocol     string            `yaml:"protocol,omitempty" json:"protocol,omitempty"`
+	Retry        RetryConfig       `yaml:"retry,omitempty" json:"retry,omitzero"`
+	Family       string            `yaml:"family,omitempty" json:"family,omitempty"`
+	Auth         AuthConfig        `yaml:"auth,omitempty" json:"auth,omitzero"`
+	Checks       ChecksConfig      `yaml:"checks,omitempty" json:"checks,omitzero"`
+	TLS          TLSOptions        `yaml:"tls,omitempty" json:"tls,omitzero"`
+
+	line     int // where the entry starts in the site list
+	column   int
+	pos      map[string]position // where each field was set
+	problems []error             // found while reading the entry
+}
+
+// AuthConfig holds a site's credentials. Type is one of the AUTHTYPE
+// values of the tab separated format.
+type AuthConfig struct {
+	Type     string   `yaml:"type,omitempty" json:"type,omitempty"`
+	User     string   `yaml:"user,omitempty" json:"user,omitempty"`
+	Password string   `yaml:"password,omitempty" json:"password,omitempty"`
+	TokenURL string   `yaml:"token_url,omitempty" json:"token_url,omitempty"`
+	Scopes   []string `yaml:"scopes,omitempty" json:"scopes,omitempty"`
+}
+
+// Site list formats
+const (
+	formatTSV  = "tsv"
+	formatYAML = "yaml"
+	formatJSON = "json"
+)
+
+func siteListFormat(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".json":
+		return formatJSON
+	}
+
+	return formatTSV
+}
+
+// position is a line and column in a site list, both starting at 1.
+type position struct {
+	line   int
+	column int
+}
+
+func newPosition(line, column int) position {
+	return position{line, column}
+}
+
+// siteListError is a problem at a position in a site list.
+type siteListError struct {
+	line   int
+	column int
+	err    error
+}
+
+func (e *siteListError) Error() string {
+	if e.line == 0 {
+		return e.err.Error()
+	}
+	if e.column > 0 {
+		return fmt.Sprintf("%d:%d: %v", e.line, e.column, e.err)
+	}
+	return fmt.Sprintf("%d: %v", e.line, e.err)
+}
+
+func (e *siteListError) Unwrap() error { return e.err }
+
+// fieldError is a problem with one field of a site entry. field uses the
+// structured names, e.g. checks.body_match.
+type fieldError struct {
+	field string
+	err   error
+}
+
+func (e *fieldError) Error() string { return e.field + ": " + e.err.Error() }
+
+func (e *fieldError) Unwrap() error { return e.err }
+
+// Give err the position of the field it is about, or of the entry itself.
+func (sc SiteConfig) locate(err error) error {
+	var le *siteListError
+	if errors.As(err, &le) {
+		return err
+	}
+
+	at := position{sc.line, sc.column}
+	var fe *fieldError
+	if errors.As(err, &fe) {
+		if p, ok := sc.pos[fe.field]; ok {
+			at = p
+		}
+	}
+
+	return &siteListError{line: at.line, column: at.column, err: err}
+}
+
+// Read the site configurations from a site list in any supported format.
+// problems are positioned errors in entries that were left out; err means
+// the file could not be read at all.
+func readSiteList(fileName string) (cfg Config, problems []error, err error) {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return cfg, nil, err
+	}
+
+	if siteListFormat(fileName) == formatTSV {
+		cfg.Sites, problems, err = readTSV(bytes.NewReader(b))
+		return cfg, problems, err
+	}
+
+	return readStructured(b)
+}
+
+// JSON is a subset of YAML, so both formats go through the YAML decoder.
+// The node tree gives every field its position and catches unknown keys.
+func readStructured(b []byte) (Config, []error, error) {
+	var cfg Config
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return cfg, nil, err
+	}
+	if doc.Kind == 0 {
+		return cfg, nil, nil // empty file
+	}
+
+	if err := doc.Decode(&cfg); err != nil {
+		var te *yaml.TypeError
+		if !errors.As(err, &te) {
+			return cfg, nil, err
+		}
+		return Config{}, typeErrors(te, &doc), errors.New("site list has type errors")
+	}
+
+	var problems []error
+	root := doc.Content[0]
+	walkKeys(root, reflect.TypeOf(cfg), "", nil, &problems)
+
+	var nodes []*yaml.Node
+	if n := topLevel(root, "sites"); n != nil && n.Kind == yaml.SequenceNode {
+		nodes = n.Content
+	}
+	for i := range cfg.Sites {
+		sc := &cfg.Sites[i]
+		sc.pos = make(map[string]position)
+		if i < len(nodes) {
+			sc.line, sc.column = nodes[i].Line, nodes[i].Column
+			walkKeys(nodes[i], reflect.TypeOf(*sc), "", sc.pos, &sc.problems)
+		}
+	}
+
+	if n := topLevel(root, "modules"); n != nil && n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			name, value := n.Content[i].Value, n.Content[i+1]
+			sc, ok := cfg.Modules[name]
+			if !ok {
+				continue
+			}
+			sc.line, sc.column = value.Line, value.Column
+			sc.pos = make(map[string]position)
+			walkKeys(value, reflect.TypeOf(sc), "", sc.pos, &sc.problems)
+			cfg.Modules[name] = sc
+		}
+	}
+
+	return cfg, problems, nil
+}
+
+// The endpoint a module is built with, until a probe swaps in its target.
+const moduleEndpoint = "http://module.invalid/"
+
+// Module is a module built once when the site list is loaded. Probes share
+// its clients, payload and token cache, only the endpoint is their own.
+type Module struct {
+	sites []Site
+	named bool // the module sets a name, otherwise sites are named after the target
+}
+
+func (sc SiteConfig) module(checkOnly bool) (Module, []error) {
+	var errs []error
+	if sc.EndPoint != "" {
+		errs = append(errs, sc.locate(&fieldError{"endpoint", errors.New("not allowed in a module, it comes from the probe target")}))
+	}
+
+	sc.EndPoint = moduleEndpoint
+	sites, siteErrs := sc.sites(checkOnly)
+	return Module{sites: sites, named: sc.Name != ""}, append(errs, siteErrs...)
+}
+
+// Build every module, leaving out those with problems. checkOnly is as for
+// SiteConfig.Site.
+func buildModules(modules map[string]SiteConfig, checkOnly bool) (map[string]Module, map[string][]error) {
+	built := make(map[string]Module)
+	problems := make(map[string][]error)
+	for name, sc := range modules {
+		module, errs := sc.module(checkOnly)
+		if len(errs) > 0 {
+			problems[name] = errs
+			continue
+		}
+		built[name] = module
+	}
+
+	// no module asked for is a plain GET
+	if _, ok := built[""]; !ok {
+		built[""], _ = SiteConfig{}.module(checkOnly)
+	}
+
+	return built, problems
+}
+
+// The sites the module probes for target. Errors are about the target, not
+// a place in the site list.
+func (mod Module) forTarget(target string) ([]Site, error) {
+	p, err := parseEndpoint(target)
+	if err != nil {
+		return nil, err
+	}
+	if mod.sites[0].Protocol == protocolHTTP3 && p.Scheme != "https" {
+		return nil, errors.New("http3 needs an https target")
+	}
+
+	sites := slices.Clone(mod.sites)
+	for i := range sites {
+		sites[i].EndPoint = target
+		sites[i].Host = p.Host
+		if !mod.named {
+			sites[i].Name = endpointName(sites[i].Method, p)
+		}
+	}
+
+	return sites, nil
+}
+
+// yaml.v3 reports type errors as "line N: message" strings. The column is
+// looked up in the node tree.
+func typeErrors(te *yaml.TypeError, doc *yaml.Node) []error {
+	var errs []error
+	for _, msg := range te.Errors {
+		var line, column int
+		if _, err := fmt.Sscanf(msg, "line %d:", &line); err == nil {
+			msg = strings.TrimSpace(msg[strings.Index(msg, ":")+1:])
+			column = valueColumn(doc, line, quoted(msg))
+		}
+		errs = append(errs, &siteListError{line: line, column: column, err: errors.New(msg)})
+	}
+	return errs
+}
+
+// The value a type error message quotes between backticks, if any.
+func quoted(msg string) string {
+	_, rest, ok := strings.Cut(msg, "`")
+	if !ok {
+		return ""
+	}
+	value, _, _ := strings.Cut(rest, "`")
+	return value
+}
+
+// The column of the value on line that a type error is about: the one
+// whose value the error quotes, else the first on the line. Keys are not
+// values, so "attempts: many" gives the column of many.
+func valueColumn(node *yaml.Node, line int, value string) int {
+	var first int
+	var walk func(n *yaml.Node, isKey bool) bool
+	walk = func(n *yaml.Node, isKey bool) bool {
+		if n.Line == line && !isKey {
+			if value != "" && n.Value == value {
+				first = n.Column
+				return true
+			}
+			if first == 0 {
+				first = n.Column
+			}
+		}
+		for i, c := range n.Content {
+			if walk(c, n.Kind == yaml.MappingNode && i%2 == 0) {
+				return true
+			}
+		}
+		return false
+	}
+	walk(node, false)
+	return first
+}
+
+// Walk a mapping node against the yaml tags of struct type t, recording
+// where each field is set in pos and reporting keys t does not have.
+// Nested structs and lists of structs are walked too, the sites list is
+// left to the caller.
+func walkKeys(node *yaml.Node, t reflect.Type, prefix string, pos map[string]position, problems *[]error) {
+	if node.Kind != yaml.MappingNode || t.Kind() != reflect.Struct {
+		return
+	}
+
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name != "" && name != "-" {
+			fields[name] = t.Field(i).Type
+		}
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		ft, ok := fields[key.Value]
+		if !ok {
+			*problems = append(*problems, &siteListError{
+				line:   key.Line,
+				column: key.Column,
+				err:    fmt.Errorf("unknown field: %s%s", prefix, key.Value),
+			})
+			continue
+		}
+
+		name := prefix + key.Value
+		if pos != nil {
+			pos[name] = position{value.Line, value.Column}
+		}
+
+		switch {
+		case ft.Kind() == reflect.Struct:
+			walkKeys(value, ft, name+".", pos, problems)
+		case ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Struct && name != "sites":
+			for _, item := range value.Content {
+				walkKeys(item, ft.Elem(), name+".", nil, problems)
+			}
+		}
+	}
+}
+
+// Find the value of a top level key.
+func topLevel(root *yaml.Node, key string) *yaml.Node {
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == key {
+			return root.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// Write the site list in from, in any format, to the YAML or JSON file to.
+// A list with problems is not converted, as the entries and options they
+// are in would be left out.
+func convertSiteList(from, to string) error {
+	format := siteListFormat(to)
+	if format == formatTSV {
+		return fmt.Errorf("%s: convert target must end in .yaml, .yml or .json", to)
+	}
+
+	cfg, problems, err := readSiteList(from)
+	if err == nil {
+		for _, sc := range cfg.Sites {
+			problems = append(problems, sc.problems...)
+		}
+		for _, sc := range cfg.Modules {
+			problems = append(problems, sc.problems...)
+		}
+	}
+	sortProblems(problems)
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "%s:%v\n", from, p)
+	}
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: %d problems, %s not written", from, len(problems), to)
+	}
+
+	var out []byte
+	if format == formatJSON {
+		out, err = json.MarshalIndent(cfg, "", "  ")
+		out = append(out, '\n')
+	} else {
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		err = enc.Encode(cfg)
+		enc.Close()
+		out = buf.Bytes()
+	}
+	if err != nil {
+		return err
+	}
+
+	// the site list may hold credentials
+	return os.WriteFile(to, out, 0600)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeSiteList writes a site list into a temporary directory and returns
+// its path. The extension picks the format.
+func writeSiteList(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadSiteList(t *testing.T) {
+	tests := []struct {
+		name, content string
+	}{
+		{"sites.tsv", "ENDPOINT\tMETHOD\tAUTHTYPE\tUSER\tPASSWORD\tACCEPT\tPAYLOAD\n" +
+			"# not a site\n" +
+			"https://api.example.com/health\tPOST\tbasic\tprobe\tenv:PROBE_PASSWORD\tapplication/json\t{\"ping\": true}\t" +
+			"name=api\tinterval=30s\tlabel=team:web\tstatus=200,204\tretry_attempts=3\n"},
+		{"sites.yaml", `sites:
+  - name: api
+    endpoint: https://api.example.com/health
+    method: POST
+    accept: application/json
+    payload: '{"ping": true}'
+    interval: 30s
+    labels:
+      team: web
+    retry:
+      attempts: 3
+    auth:
+      type: basic
+      user: probe
+      password: env:PROBE_PASSWORD
+    checks:
+      status: [200, 204]
+`},
+		{"sites.json", `{"sites": [{
+  "name": "api",
+  "endpoint": "https://api.example.com/health",
+  "method": "POST",
+  "accept": "application/json",
+  "payload": "{\"ping\": true}",
+  "interval": "30s",
+  "labels": {"team": "web"},
+  "retry": {"attempts": 3},
+  "auth": {"type": "basic", "user": "probe", "password": "env:PROBE_PASSWORD"},
+  "checks": {"status": ["200", "204"]}
+}]}`},
+	}
+
+	for _, tt := range tests {
+		cfg, problems, err := readSiteList(writeSiteList(t, tt.name, tt.content))
+		if err != nil || len(problems) > 0 {
+			t.Errorf("%s: %v %v", tt.name, err, problems)
+			continue
+		}
+		if len(cfg.Sites) != 1 {
+			t.Errorf("%s: got %d sites, want 1", tt.name, len(cfg.Sites))
+			continue
+		}
+
+		sc := cfg.Sites[0]
+		if len(sc.problems) > 0 {
+			t.Errorf("%s: %v", tt.name, sc.problems)
+		}
+		got := []string{sc.Name, sc.EndPoint, sc.Method, sc.Accept, sc.Payload, sc.Interval,
+			sc.Labels["team"], sc.Auth.Type, sc.Auth.User, sc.Auth.Password, strings.Join(sc.Checks.Status, ",")}
+		want := []string{"api", "https://api.example.com/health", "POST", "application/json", `{"ping": true}`, "30s",
+			"web", "basic", "probe", "env:PROBE_PASSWORD", "200,204"}
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, want)
+		}
+		if sc.Retry.Attempts != 3 {
+			t.Errorf("%s: got %d retry attempts, want 3", tt.name, sc.Retry.Attempts)
+		}
+	}
+}
+
+func TestReadSiteListProblems(t *testing.T) {
+	const header = "ENDPOINT\tMETHOD\tAUTHTYPE\tUSER\tPASSWORD\tACCEPT\tPAYLOAD\n"
+
+	tests := []struct {
+		name, content string
+		want          string // the first problem
+	}{
+		{"short.tsv", header + "https://example.com/\tGET\n", "2:22: expected at least 7 columns, got 2"},
+		{"option.tsv", header + "https://example.com/\tGET\t\t\t\t\t\tinterval\n", "2:31: option is not key=value"},
+		{"unknown.tsv", header + "https://example.com/\tGET\t\t\t\t\t\tbogus=1\n", "2:31: unknown option: bogus"},
+		{"unknown.yaml", "sites:\n  - endpoint: https://example.com/\n    bogus: 1\n", "3:5: unknown field: bogus"},
+		{"nested.yaml", "sites:\n  - endpoint: https://example.com/\n    checks:\n      bogus: 1\n", "4:7: unknown field: checks.bogus"},
+		{"top.json", `{"sites": [], "bogus": 1}`, "1:15: unknown field: bogus"},
+		{"type.yaml", "sites:\n  - endpoint: https://example.com/\n    headers: [a, b]\n", "3:14: cannot unmarshal"},
+		{"value.yaml", "sites:\n  - endpoint: https://example.com/\n    retry: {attempts: many}\n", "3:23: cannot unmarshal !!str `many`"},
+	}
+
+	for _, tt := range tests {
+		cfg, problems, _ := readSiteList(writeSiteList(t, tt.name, tt.content))
+		for _, sc := range cfg.Sites {
+			problems = append(problems, sc.problems...)
+		}
+		if len(problems) == 0 {
+			t.Errorf("%s: no problems, want %q", tt.name, tt.want)
+			continue
+		}
+		if got := problems[0].Error(); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReadSites(t *testing.T) {
+	path := writeSiteList(t, "sites.yaml", `sites:
+  - endpoint: https://good.example.com/
+  - endpoint: https://bad.example.com/
+    interval: soon
+`)
+
+	// a reload takes the whole list or nothing
+	if sites, _, err := readSites(path); err == nil || sites != nil {
+		t.Errorf("readSites accepted a list with a bad entry: %d sites", len(sites))
+	} else if !strings.Contains(err.Error(), "4:15: interval") {
+		t.Errorf("readSites: got %v, want the bad interval located", err)
+	}
+
+	// the first load skips the bad entry
+	sites, _ := loadSites(path)
+	if sites == nil || len(*sites) != 1 || (*sites)[0].Name != "good.example.com" {
+		t.Errorf("loadSites: got %v, want good.example.com alone", sites)
+	}
+}
+
+func TestCheckSiteList(t *testing.T) {
+	tests := []struct {
+		content string
+		want    int // problems
+	}{
+		// files and secrets are where the exporter runs
+		{`sites:
+  - endpoint: https://api.example.com/
+    payload: '@/missing/payload.json'
+    auth:
+      type: oauth2
+      user: client-id
+      password: env:MISSING_SECRET
+      token_url: https://auth.example.com/token
+    tls:
+      ca: /missing/ca.crt
+      cert: /missing/client.crt
+      key: /missing/client.key
+`, 0},
+		{`sites:
+  - endpoint: https://api.example.com/
+    payload: '@'
+    auth:
+      type: basic
+      password: credential:a/b
+    tls:
+      cert: /missing/client.crt
+`, 3},
+	}
+
+	for _, tt := range tests {
+		var out strings.Builder
+		if got := checkSiteList(writeSiteList(t, "sites.yaml", tt.content), &out); got != tt.want {
+			t.Errorf("got %d problems, want %d:\n%s", got, tt.want, out.String())
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// proxy value that ignores HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+const proxyDirect = "direct"
+
+func validProxy(proxy string) error {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return err
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("scheme must be http, https, socks5 or socks5h: %q", proxy)
+	}
+
+	if u.Host == "" {
+		return fmt.Errorf("no host: %q", proxy)
+	}
+
+	return nil
+}
+
+// Dial over the site's address family with its dialer.
+func (site *Site) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return site.dialer().DialContext(ctx, site.network(network), addr)
+}
+
+// The dialer a site's probes connect with, bound to its source address
+// and interface when it has them. A proxy is dialed the same way.
+func (site *Site) dialer() *net.Dialer {
+	d := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	if site.SourceAddr != nil {
+		d.LocalAddr = &net.TCPAddr{IP: site.SourceAddr}
+	}
+
+	if site.Interface != "" {
+		d.Control = bindToDevice(site.Interface)
+	}
+
+	return d
+}
@@ -0,0 +1,23 @@
+//go:build linux
+
+package main
+
+import (
+	"syscall"
+)
+
+// Bind sockets to a network interface with SO_BINDTODEVICE. The kernel
+// wants CAP_NET_RAW for this unless the interface is already the route to
+// the target.
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			serr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	}
+}
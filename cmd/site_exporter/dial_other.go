@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Binding to an interface needs SO_BINDTODEVICE, which only linux has.
+// Use source_address instead.
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return fmt.Errorf("interface %s: binding to an interface is only supported on linux", iface)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"syscall"
+)
+
+// reason label values of http_request_failure_total
+const (
+	reasonDNS     = "dns"
+	reasonRefused = "refused"
+	reasonTimeout = "timeout"
+	reasonTLS     = "tls"
+	reasonReset   = "reset"
+	reasonOther   = "other"
+)
+
+// Classify why a request failed. A lookup that times out is a DNS failure,
+// and so is a host without an address of the family asked for.
+func failureReason(err error) string {
+	var dnsErr *net.DNSError
+	var addrErr *net.AddrError
+	if errors.As(err, &dnsErr) || errors.As(err, &addrErr) {
+		return reasonDNS
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return reasonTimeout
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return reasonRefused
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return reasonReset
+	}
+
+	var (
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+		verifyErr    *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	if errors.As(err, &recordErr) || errors.As(err, &alertErr) || errors.As(err, &verifyErr) ||
+		errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+		return reasonTLS
+	}
+
+	return reasonOther
+}
+
+// Count a failed request and drop the series describing the last good
+// response, so a dead site does not go on reporting its old duration,
+// handshake, protocol, final URL or address.
+func (m *Metrics) failed(site Site, err error) {
+	reason := failureReason(err)
+	if errors.Is(err, context.DeadlineExceeded) {
+		m.timedOut(site)
+	} else {
+		log.Printf("%s: %s: %v\n", site.EndPoint, reason, err)
+	}
+
+	if c, err := m.HttpRequestFailureTotal.GetMetricWithLabelValues(m.values(site, reason)...); err == nil {
+		c.Inc()
+	} else {
+		log.Println(err)
+	}
+
+	m.HttpDuration.DeleteLabelValues(m.values(site)...)
+	m.HttpPhaseDuration.DeletePartialMatch(m.partial(site))
+	m.TLSInfo.DeletePartialMatch(m.partial(site))
+	m.TLSHostnameVerified.DeleteLabelValues(m.values(site)...)
+	m.HttpRedirects.DeleteLabelValues(m.values(site)...)
+	m.HttpFinalURL.DeletePartialMatch(m.partial(site))
+	m.HttpProtocolInfo.DeletePartialMatch(m.partial(site))
+	m.HttpProbeIP.DeletePartialMatch(m.partial(site))
+}
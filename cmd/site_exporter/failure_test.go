@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestFailedDropsLastResponse(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	sc := SiteConfig{EndPoint: srv.URL, TLS: TLSOptions{InsecureSkipVerify: true}}
+	site, errs := sc.Site(false)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	m := NewMetrics(nil)
+	series := map[string]prometheus.Collector{
+		"duration":          m.HttpDuration,
+		"phase duration":    m.HttpPhaseDuration,
+		"tls info":          m.TLSInfo,
+		"hostname verified": m.TLSHostnameVerified,
+		"redirects":         m.HttpRedirects,
+		"final url":         m.HttpFinalURL,
+		"protocol":          m.HttpProtocolInfo,
+		"probe ip":          m.HttpProbeIP,
+	}
+
+	m.probe(context.Background(), site)
+	for name, c := range series {
+		if testutil.CollectAndCount(c) == 0 {
+			t.Errorf("%s: no series after a good probe", name)
+		}
+	}
+
+	srv.Close()
+	m.probe(context.Background(), site)
+	for name, c := range series {
+		if n := testutil.CollectAndCount(c); n > 0 {
+			t.Errorf("%s: %d series left after a failed probe", name, n)
+		}
+	}
+	if got := testutil.ToFloat64(m.HttpRequestFailureTotal.WithLabelValues(site.Name, reasonRefused)); got != 1 {
+		t.Errorf("got %v refused failures, want 1", got)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"strings"
+)
+
+// family values. A site of family both is probed once over each.
+const (
+	familyAny  = ""
+	familyIPv4 = "ipv4"
+	familyIPv6 = "ipv6"
+	familyBoth = "both"
+)
+
+func parseFamily(value string) (string, error) {
+	switch f := strings.ToLower(value); f {
+	case familyAny, familyIPv4, familyIPv6, familyBoth:
+		return f, nil
+	}
+
+	return "", fmt.Errorf("must be ipv4, ipv6 or both: %q", value)
+}
+
+// The sites a site list entry stands for: itself, or one per address
+// family when its family is both. Those carry a family label.
+func (sc SiteConfig) sites(checkOnly bool) ([]Site, []error) {
+	if !strings.EqualFold(sc.Family, familyBoth) {
+		site, errs := sc.Site(checkOnly)
+		return []Site{site}, errs
+	}
+
+	var sites []Site
+	for _, family := range []string{familyIPv4, familyIPv6} {
+		c := sc
+		c.Family = family
+		site, errs := c.Site(checkOnly)
+		if len(errs) > 0 {
+			return []Site{site}, errs
+		}
+		sites = append(sites, site)
+	}
+
+	return sites, nil
+}
+
+// Label the site with its address family, if it has one.
+func (site *Site) labelFamily() {
+	if site.Family == familyAny {
+		return
+	}
+
+	labels := maps.Clone(site.Labels)
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels["family"] = site.Family
+	site.Labels = labels
+}
+
+// The network to dial for the site's address family, tcp4 for tcp over
+// ipv4 and so on.
+func (site *Site) network(network string) string {
+	switch site.Family {
+	case familyIPv4:
+		return network + "4"
+	case familyIPv6:
+		return network + "6"
+	}
+
+	return network
+}
+
+// The key a site's probe results are kept under. Both families of a site
+// share its name.
+func (site Site) key() string {
+	return site.Name + " " + site.Family
+}
+
+func (m *Metrics) recordIP(site Site, pt *phaseTimer) {
+	host, _, err := net.SplitHostPort(pt.remoteAddr())
+	if err != nil {
+		return
+	}
+
+	// the resolver may return another address next time, keep only this one
+	m.HttpProbeIP.DeletePartialMatch(m.partial(site))
+	if g, err := m.HttpProbeIP.GetMetricWithLabelValues(m.values(site, host)...); err == nil {
+		g.Set(1)
+	} else {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// leave Prometheus this much of its scrape timeout to receive the answer
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
+// ProbeHandler runs a single probe for the target in the query, in the
+// style of the blackbox_exporter:
+//
+//	/probe?target=https://example.com/health&module=json_api
+//
+// module names an entry under modules in the site list. Without one the
+// target gets a plain GET that must answer 2xx. The results are served from
+// a registry made for this request, so nothing is kept between requests.
+func (ssc *SiteStatCollector) ProbeHandler(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+
+	name := r.URL.Query().Get("module")
+	ssc.mu.RLock()
+	module, ok := ssc.modules[name]
+	ssc.mu.RUnlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown module %q", name), http.StatusBadRequest)
+		return
+	}
+
+	sites, err := module.forTarget(target)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("target %q: %v", target, err), http.StatusBadRequest)
+		return
+	}
+
+	timeout := ssc.probeTimeout(r, sites[0])
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	// module labels do not apply, only the family label when there is one
+	var labelNames []string
+	if sites[0].Family != familyAny {
+		labelNames = []string{"family"}
+	}
+	m := NewMetrics(labelNames)
+	reg := prometheus.NewRegistry()
+	m.register(reg)
+
+	// a module of family both probes over each at the same time
+	var wg sync.WaitGroup
+	for _, site := range sites {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.probe(ctx, site)
+		}()
+	}
+	wg.Wait()
+	log.Printf("%s probe %s module=%q\n", r.RemoteAddr, target, name)
+
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// The module's timeout wins, then the scrape timeout Prometheus sends
+// along, then the -timeout default.
+func (ssc *SiteStatCollector) probeTimeout(r *http.Request, site Site) time.Duration {
+	if site.Timeout > 0 {
+		return site.Timeout
+	}
+
+	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil {
+			d := time.Duration(secs*float64(time.Second)) - scrapeTimeoutOffset
+			if d > 0 {
+				return d
+			}
+		}
+	}
+
+	return ssc.timeout
+}
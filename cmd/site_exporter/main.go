@@ -27,10 +27,16 @@ var (
 
 type FlagData struct {
 	Bind        string
+	CheckConfig bool
+	Concurrency int
+	Convert     string
+	Interval    time.Duration
 	LogFh       *os.File
 	LogFileName string
 	Port        string
 	SiteList    string
+	Timeout     time.Duration
+	Watch       bool
 }
 
 func Version(b bool) {
@@ -56,6 +62,12 @@ func Initialize() FlagData {
 	flag.StringVar(&ip, "ip", "0.0.0.0", "Server bind IP address")
 	flag.IntVar(&port, "port", 9400, "Server bind port")
 	flag.StringVar(&fd.SiteList, "site-list", "", "Location of site list file")
+	flag.IntVar(&fd.Concurrency, "concurrency", 10, "Maximum number of sites probed at once")
+	flag.DurationVar(&fd.Interval, "interval", time.Minute, "Default time between probes of a site")
+	flag.DurationVar(&fd.Timeout, "timeout", 10*time.Second, "Default deadline for a single probe")
+	flag.BoolVar(&fd.Watch, "watch", true, "Reload the site list when the file changes")
+	flag.BoolVar(&fd.CheckConfig, "check-config", false, "Validate the site list, report every problem and exit")
+	flag.StringVar(&fd.Convert, "convert", "", "Write the site list to this .yaml or .json file and exit")
 	flag.BoolVar(&v, "version", false, "Display the version and exit")
 	flag.Parse()
 
@@ -69,6 +81,33 @@ func Initialize() FlagData {
 		log.Fatal(err)
 	}
 
+	if fd.CheckConfig {
+		if checkSiteList(fd.SiteList, os.Stderr) > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if fd.Convert != "" {
+		if err := convertSiteList(fd.SiteList, fd.Convert); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s: converted to %s\n", fd.SiteList, fd.Convert)
+		os.Exit(0)
+	}
+
+	if fd.Concurrency < 1 {
+		log.Fatalf("concurrency must be at least 1: %v\n", fd.Concurrency)
+	}
+
+	if fd.Interval <= 0 {
+		log.Fatalf("interval must be positive: %v\n", fd.Interval)
+	}
+
+	if fd.Timeout <= 0 {
+		log.Fatalf("timeout must be positive: %v\n", fd.Timeout)
+	}
+
 	if tmp := net.ParseIP(ip); tmp == nil {
 		log.Fatalf("invalid IP address: %s\n", ip)
 	}
@@ -112,14 +151,14 @@ func StartLog(fd *FlagData) error {
 	return nil
 }
 
-func sigHandler(sigChan chan os.Signal, server *http.Server, fd FlagData) {
+func sigHandler(sigChan chan os.Signal, server *http.Server, fd FlagData, ssc *SiteStatCollector) {
 	for sig := range sigChan {
 		if sig == syscall.SIGUSR1 {
 			log.Println("signal: resetting log file")
 			log.Println(fd.LogFileName)
 		} else if sig == syscall.SIGUSR2 {
-			log.Println("signal: prep for site reload")
-			siteReloadSignal = true
+			log.Println("signal: site reload")
+			ssc.Reload()
 		} else if sig == syscall.SIGTERM || sig == syscall.SIGINT {
 			log.Println("signal: shutting down")
 			ctx, shutdownRelease := context.WithTimeout(context.Background(), 5*time.Second)
@@ -136,9 +175,15 @@ func sigHandler(sigChan chan os.Signal, server *http.Server, fd FlagData) {
 func main() {
 	fd := Initialize()
 
-	ssc := NewSiteStatCollector(fd.SiteList)
+	ssc := NewSiteStatCollector(fd)
 	prometheus.Register(ssc)
+	if fd.Watch {
+		if err := ssc.Watch(); err != nil {
+			log.Printf("not watching site list: %v\n", err)
+		}
+	}
 	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/probe", ssc.ProbeHandler)
 
 	server := &http.Server{
 		Addr: fd.Bind + ":" + fd.Port,
@@ -146,7 +191,7 @@ func main() {
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2)
-	go sigHandler(sigChan, server, fd)
+	go sigHandler(sigChan, server, fd, ssc)
 
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatal(err)
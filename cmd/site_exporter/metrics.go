@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Label names the metrics use themselves, which a site's own labels may
+// not take
+var reservedLabels = []string{
+	"site", "code", "reason", "check", "version", "cipher", "phase", "url",
+	"protocol", "ip", "family", "le", "quantile",
+}
+
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Metrics are the series a probe produces. The site list collector keeps
+// one set while the label names in the site list stay the same, the /probe
+// handler makes a fresh set for every request.
+type Metrics struct {
+	labelNames []string // the sites' own labels, after those of each metric
+
+	HttpRequestAttemptsTotal *prometheus.CounterVec
+	HttpRequestSuccessTotal  *prometheus.CounterVec
+	HttpDuration             *prometheus.GaugeVec
+	HttpDurationBucket       *prometheus.HistogramVec
+	HttpRequestTimeoutTotal  *prometheus.CounterVec
+	HttpRequestFailureTotal  *prometheus.CounterVec
+	HttpRequestRetryTotal    *prometheus.CounterVec
+	HttpProbeSuccess         *prometheus.GaugeVec
+	HttpCheckFailureTotal    *prometheus.CounterVec
+	AuthTokenFailureTotal    *prometheus.CounterVec
+	TLSCertNotAfter          *prometheus.GaugeVec
+	TLSChainNotAfter         *prometheus.GaugeVec
+	TLSInfo                  *prometheus.GaugeVec
+	TLSHostnameVerified      *prometheus.GaugeVec
+	HttpPhaseDuration        *prometheus.GaugeVec
+	HttpPhaseDurationBucket  *prometheus.HistogramVec
+	HttpRedirects            *prometheus.GaugeVec
+	HttpFinalURL             *prometheus.GaugeVec
+	HttpProtocolInfo         *prometheus.GaugeVec
+	HttpProbeAge             *prometheus.GaugeVec
+	HttpProbeIP              *prometheus.GaugeVec
+}
+
+// NewMetrics makes the metrics for sites carrying the given labels of
+// their own. A site without one of them gets it empty.
+func NewMetrics(labelNames []string) *Metrics {
+	m := Metrics{labelNames: labelNames}
+
+	m.HttpRequestAttemptsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_request_attempt_total",
+		Help: "Total number of requests partitioned by site",
+	},
+		m.names("site"),
+	)
+
+	m.HttpRequestSuccessTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_request_success_total",
+		Help: "Total number of requests partitioned by site and HTTP status code",
+	},
+		m.names("site", "code"),
+	)
+
+	m.HttpDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_request_duration_seconds",
+		Help: "Duration of HTTP requests",
+	},
+		m.names("site"),
+	)
+
+	m.HttpDurationBucket = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds_bucket",
+		Help:    "Duration of HTTP requests bucketed by histogram and partitioned by site",
+		Buckets: []float64{0.001, 0.01, 0.1, 0.25, 0.5, 1, 2, 5},
+	},
+		m.names("site"),
+	)
+
+	m.HttpRequestTimeoutTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_request_timeout_total",
+		Help: "Total number of requests that missed the probe deadline partitioned by site",
+	},
+		m.names("site"),
+	)
+
+	m.HttpRequestFailureTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_request_failure_total",
+		Help: "Total number of requests that got no response partitioned by site and reason",
+	},
+		m.names("site", "reason"),
+	)
+
+	m.HttpRequestRetryTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_request_retry_total",
+		Help: "Total number of requests tried again within a probe partitioned by site and reason",
+	},
+		m.names("site", "reason"),
+	)
+
+	m.HttpProbeSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_probe_success",
+		Help: "Whether the last probe passed every check (1) or not (0) partitioned by site",
+	},
+		m.names("site"),
+	)
+
+	m.HttpCheckFailureTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_probe_check_failure_total",
+		Help: "Total number of failed response checks partitioned by site and check",
+	},
+		m.names("site", "check"),
+	)
+
+	m.AuthTokenFailureTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_auth_token_failure_total",
+		Help: "Total number of failed OAuth2 token requests partitioned by site",
+	},
+		m.names("site"),
+	)
+
+	m.TLSCertNotAfter = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_tls_cert_not_after_timestamp_seconds",
+		Help: "Expiry of the leaf certificate in unixtime partitioned by site",
+	},
+		m.names("site"),
+	)
+
+	m.TLSChainNotAfter = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_tls_chain_not_after_timestamp_seconds",
+		Help: "Earliest expiry across the presented certificate chain in unixtime partitioned by site",
+	},
+		m.names("site"),
+	)
+
+	m.TLSInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_tls_info",
+		Help: "Negotiated TLS version and cipher suite partitioned by site",
+	},
+		m.names("site", "version", "cipher"),
+	)
+
+	m.TLSHostnameVerified = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_tls_hostname_verified",
+		Help: "Whether the leaf certificate is valid for the requested host name partitioned by site",
+	},
+		m.names("site"),
+	)
+
+	m.HttpPhaseDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_request_phase_last_duration_seconds",
+		Help: "Duration of each phase of the last HTTP request partitioned by site and phase",
+	},
+		m.names("site", "phase"),
+	)
+
+	m.HttpPhaseDurationBucket = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_phase_duration_seconds",
+		Help:    "Duration of each phase of HTTP requests bucketed by histogram and partitioned by site and phase",
+		Buckets: []float64{0.001, 0.01, 0.1, 0.25, 0.5, 1, 2, 5},
+	},
+		m.names("site", "phase"),
+	)
+
+	m.HttpRedirects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_redirects",
+		Help: "Number of redirect responses the last probe got partitioned by site",
+	},
+		m.names("site"),
+	)
+
+	m.HttpFinalURL = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_final_url_info",
+		Help: "URL the last probe ended up at after redirects partitioned by site",
+	},
+		m.names("site", "url"),
+	)
+
+	m.HttpProtocolInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_protocol_info",
+		Help: "Protocol of the last probe response partitioned by site",
+	},
+		m.names("site", "protocol"),
+	)
+
+	m.HttpProbeAge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_probe_age_seconds",
+		Help: "Seconds since the cached probe result was taken partitioned by site",
+	},
+		m.names("site"),
+	)
+
+	m.HttpProbeIP = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_probe_ip_info",
+		Help: "Address the last probe connected to partitioned by site",
+	},
+		m.names("site", "ip"),
+	)
+
+	return &m
+}
+
+// Label names of a metric: its own, then the sites' labels.
+func (m *Metrics) names(names ...string) []string {
+	return append(names, m.labelNames...)
+}
+
+// Label values of a site's series, in the order of names.
+func (m *Metrics) values(site Site, values ...string) []string {
+	values = append([]string{site.Name}, values...)
+	for _, name := range m.labelNames {
+		values = append(values, site.Labels[name])
+	}
+	return values
+}
+
+// Labels matching every series of one site.
+func (m *Metrics) partial(site Site) prometheus.Labels {
+	labels := prometheus.Labels{"site": site.Name}
+	for _, name := range m.labelNames {
+		labels[name] = site.Labels[name]
+	}
+	return labels
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.HttpRequestAttemptsTotal,
+		m.HttpRequestSuccessTotal,
+		m.HttpDuration,
+		m.HttpDurationBucket,
+		m.HttpRequestTimeoutTotal,
+		m.HttpRequestFailureTotal,
+		m.HttpRequestRetryTotal,
+		m.HttpProbeSuccess,
+		m.HttpCheckFailureTotal,
+		m.AuthTokenFailureTotal,
+		m.TLSCertNotAfter,
+		m.TLSChainNotAfter,
+		m.TLSInfo,
+		m.TLSHostnameVerified,
+		m.HttpPhaseDuration,
+		m.HttpPhaseDurationBucket,
+		m.HttpRedirects,
+		m.HttpFinalURL,
+		m.HttpProtocolInfo,
+		m.HttpProbeAge,
+		m.HttpProbeIP,
+	}
+}
+
+func (m *Metrics) register(reg prometheus.Registerer) {
+	reg.MustRegister(m.collectors()...)
+}
+
+// Drop every series of a site.
+func (m *Metrics) forget(site Site) {
+	for _, c := range m.collectors() {
+		if vec, ok := c.(interface {
+			DeletePartialMatch(prometheus.Labels) int
+		}); ok {
+			vec.DeletePartialMatch(m.partial(site))
+		}
+	}
+}
+
+// Whether two sites produce the same series.
+func (site Site) sameSeries(other Site) bool {
+	return site.Name == other.Name && maps.Equal(site.Labels, other.Labels)
+}
+
+// The sorted names of all labels the sites carry.
+func siteLabelNames(sites []Site) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, site := range sites {
+		for name := range site.Labels {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validLabelName(name string) error {
+	if !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__") {
+		return fmt.Errorf("invalid label name: %q", name)
+	}
+	if slices.Contains(reservedLabels, name) {
+		return fmt.Errorf("label name is used by the metrics: %q", name)
+	}
+	return nil
+}
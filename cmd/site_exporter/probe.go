@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"slices"
+	"strconv"
+	"time"
+)
+
+func (m *Metrics) timedOut(site Site) {
+	log.Printf("%s: probe deadline exceeded\n", site.EndPoint)
+	if c, err := m.HttpRequestTimeoutTotal.GetMetricWithLabelValues(m.values(site)...); err == nil {
+		c.Inc()
+	} else {
+		log.Println(err)
+	}
+}
+
+func (m *Metrics) probe(ctx context.Context, site Site) {
+	if c, err := m.HttpRequestAttemptsTotal.GetMetricWithLabelValues(m.values(site)...); err == nil {
+		c.Inc()
+	} else {
+		log.Println(err)
+	}
+
+	var body io.Reader
+	if len(site.body) > 0 {
+		body = bytes.NewReader(site.body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, site.Method, site.EndPoint, body)
+	if err != nil {
+		log.Println(err)
+		m.setSuccess(site, false)
+		return
+	}
+	req.Header.Add(`Accept`, site.Accept)
+	if body != nil && site.ContentType != "" {
+		req.Header.Set(`Content-Type`, site.ContentType)
+	}
+	for name, value := range site.Headers {
+		req.Header.Set(name, value)
+	}
+	if err := site.authorize(ctx, req); err != nil {
+		log.Printf("%s: auth: %v\n", site.EndPoint, err)
+		if site.AuthType == authOAuth2 {
+			if c, err := m.AuthTokenFailureTotal.GetMetricWithLabelValues(m.values(site)...); err == nil {
+				c.Inc()
+			} else {
+				log.Println(err)
+			}
+		}
+		m.setSuccess(site, false)
+		return
+	}
+
+	// keep-alives are off for HTTP/1.1 and HTTP/2, HTTP/3 has to be told
+	defer site.client.CloseIdleConnections()
+
+	// only the last try is reported, earlier ones count as retries
+	var (
+		resp  *http.Response
+		pt    *phaseTimer
+		rs    *redirectState
+		start time.Time
+	)
+	cancel := func() {}
+	defer func() { cancel() }()
+	for try := 1; ; try++ {
+		cancel()
+		var tctx context.Context
+		tctx, cancel = site.Retry.tryContext(ctx, try)
+		pt = newPhaseTimer()
+		tctx, rs = withRedirectState(httptrace.WithClientTrace(tctx, pt.trace()))
+		treq := req.Clone(tctx)
+		if req.GetBody != nil {
+			treq.Body, _ = req.GetBody()
+		}
+
+		start = time.Now()
+		resp, err = site.client.Do(treq)
+		reason, retry := site.Retry.retryable(resp, err)
+		if !retry || try >= site.Retry.Attempts || !site.Retry.wait(ctx, try) {
+			break
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		m.retried(site, reason)
+	}
+	if err != nil {
+		m.failed(site, err)
+		m.setSuccess(site, false)
+		return
+	}
+	duration := time.Since(start).Seconds()
+	statusCode := strconv.Itoa(resp.StatusCode)
+	m.recordTLS(site, resp)
+	m.recordRedirects(site, resp, rs)
+	m.recordProtocol(site, resp)
+
+	// only body checks need the body kept, otherwise it is read and dropped
+	var respBody []byte
+	if site.Checks.needsBody() {
+		var reader io.Reader = resp.Body
+		if site.Checks.MaxBody > 0 {
+			reader = io.LimitReader(resp.Body, site.Checks.MaxBody+1)
+		}
+		respBody, err = io.ReadAll(reader)
+	} else {
+		_, err = io.Copy(io.Discard, resp.Body)
+	}
+	resp.Body.Close()
+	if err != nil {
+		log.Printf("%s: reading body: %v\n", site.EndPoint, err)
+	}
+	pt.done()
+	m.recordPhases(site, pt)
+	m.recordIP(site, pt)
+
+	if d, err := m.HttpDuration.GetMetricWithLabelValues(m.values(site)...); err == nil {
+		d.Set(float64(duration))
+	} else {
+		log.Println(err)
+	}
+
+	if c, err := m.HttpRequestSuccessTotal.GetMetricWithLabelValues(m.values(site, statusCode)...); err == nil {
+		c.Inc()
+	} else {
+		log.Println(err)
+	}
+
+	g, err := m.HttpDurationBucket.GetMetricWithLabelValues(m.values(site)...)
+	if err != nil {
+		log.Println(err)
+	} else {
+		g.Observe(float64(duration))
+	}
+
+	// a probe stopped at a redirect fails on why it stopped, not on the
+	// status of the redirect
+	failed := site.Checks.Run(resp, respBody)
+	if len(rs.failed) > 0 {
+		failed = slices.DeleteFunc(failed, func(check string) bool { return check == checkStatus })
+	}
+	failed = append(rs.failed, failed...)
+	for _, check := range failed {
+		log.Printf("%s: check failed: %s\n", site.EndPoint, check)
+		if c, err := m.HttpCheckFailureTotal.GetMetricWithLabelValues(m.values(site, check)...); err == nil {
+			c.Inc()
+		} else {
+			log.Println(err)
+		}
+	}
+	m.setSuccess(site, len(failed) == 0)
+}
+
+func (m *Metrics) setSuccess(site Site, ok bool) {
+	v := 0.0
+	if ok {
+		v = 1
+	}
+
+	if g, err := m.HttpProbeSuccess.GetMetricWithLabelValues(m.values(site)...); err == nil {
+		g.Set(v)
+	} else {
+		log.Println(err)
+	}
+}
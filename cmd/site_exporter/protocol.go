@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// protocol values. The default lets TLS negotiate HTTP/1.1 or HTTP/2.
+const (
+	protocolAuto  = ""
+	protocolHTTP1 = "http1.1"
+	protocolHTTP2 = "http2"
+	protocolHTTP3 = "http3"
+)
+
+// Check name for a response over a protocol other than the site's
+const checkProtocol = "protocol"
+
+// Parse the protocol setting. It returns the protocol and the HTTP major
+// version a response over it carries, 0 for any.
+func parseProtocol(value string) (string, int, error) {
+	switch p := strings.ToLower(value); p {
+	case protocolAuto:
+		return p, 0, nil
+	case protocolHTTP1:
+		return p, 1, nil
+	case protocolHTTP2:
+		return p, 2, nil
+	case protocolHTTP3:
+		return p, 3, nil
+	}
+
+	return "", 0, fmt.Errorf("must be http1.1, http2 or http3: %q", value)
+}
+
+// Restrict transport to the site's protocol. http2 over plain http is
+// h2c with prior knowledge.
+func (site *Site) setProtocols(transport *http.Transport) {
+	var protocols http.Protocols
+	switch site.Protocol {
+	case protocolHTTP1:
+		protocols.SetHTTP1(true)
+	case protocolHTTP2:
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		return
+	}
+	transport.Protocols = &protocols
+}
+
+// The HTTP/3 transport for a site. Proxies do not apply to QUIC.
+func (site *Site) http3Transport(cfg *tls.Config) *http3.Transport {
+	return &http3.Transport{
+		TLSClientConfig: cfg,
+		Dial:            site.dialQUIC,
+	}
+}
+
+// Dial a QUIC connection from a socket of its own, bound to the site's
+// source address and interface. The socket is closed with the connection,
+// so a probe leaves nothing behind once its idle connection is closed.
+func (site *Site) dialQUIC(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+	raddr, err := net.ResolveUDPAddr(site.network("udp"), addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the QUIC handshake stands in for connect, so the connect phase and
+	// the address connected to are traced as for TCP
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.ConnectStart != nil {
+		trace.ConnectStart("udp", raddr.String())
+	}
+
+	laddr := ":0"
+	if site.SourceAddr != nil {
+		laddr = net.JoinHostPort(site.SourceAddr.String(), "0")
+	}
+	lc := net.ListenConfig{}
+	if site.Interface != "" {
+		lc.Control = bindToDevice(site.Interface)
+	}
+	pconn, err := lc.ListenPacket(ctx, site.network("udp"), laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &quic.Transport{Conn: pconn}
+	conn, err := tr.DialEarly(ctx, raddr, tlsCfg, cfg)
+	if trace != nil && trace.ConnectDone != nil {
+		trace.ConnectDone("udp", raddr.String(), err)
+	}
+	if err != nil {
+		tr.Close()
+		return nil, err
+	}
+	go func() {
+		<-conn.Context().Done()
+		tr.Close()
+	}()
+
+	return conn, nil
+}
+
+func (m *Metrics) recordProtocol(site Site, resp *http.Response) {
+	// only the protocol the latest probe negotiated
+	m.HttpProtocolInfo.DeletePartialMatch(m.partial(site))
+	if g, err := m.HttpProtocolInfo.GetMetricWithLabelValues(m.values(site, resp.Proto)...); err == nil {
+		g.Set(1)
+	} else {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// redirects values besides a hop count
+const (
+	redirectFollow = "follow"
+	redirectNone   = "none"
+)
+
+// most hops followed when redirects is follow, as net/http does
+const defaultMaxRedirects = 10
+
+// Check names for redirect failures
+const (
+	checkRedirects    = "redirects"
+	checkRedirectHost = "redirect_host"
+)
+
+// Parse the redirects setting into the number of hops a probe may follow.
+func parseRedirects(value string) (int, error) {
+	switch strings.ToLower(value) {
+	case "", redirectFollow:
+		return defaultMaxRedirects, nil
+	case redirectNone:
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("must be follow, none or a number of hops: %q", value)
+	}
+
+	return n, nil
+}
+
+type redirectKey struct{}
+
+// redirectState follows one probe through its redirects. hops counts the
+// redirect responses, including one the probe stopped at.
+type redirectState struct {
+	hops   int
+	failed []string
+}
+
+func withRedirectState(ctx context.Context) (context.Context, *redirectState) {
+	rs := &redirectState{}
+	return context.WithValue(ctx, redirectKey{}, rs), rs
+}
+
+// checkRedirect is the site client's CheckRedirect. Instead of failing the
+// request it stops at the redirect response, so the probe still reports
+// the timing of the last hop, and records why it stopped.
+func (site *Site) checkRedirect(req *http.Request, via []*http.Request) error {
+	rs, _ := req.Context().Value(redirectKey{}).(*redirectState)
+	if rs == nil {
+		rs = &redirectState{}
+	}
+
+	rs.hops = len(via)
+
+	if len(via) > site.MaxRedirects {
+		rs.failed = append(rs.failed, checkRedirects)
+		return http.ErrUseLastResponse
+	}
+
+	if !site.redirectAllowed(req.URL.Hostname(), via[0].URL.Hostname()) {
+		rs.failed = append(rs.failed, checkRedirectHost)
+		return http.ErrUseLastResponse
+	}
+
+	return nil
+}
+
+// origin, the host the probe started at, is always allowed. Entries
+// starting with *. match any subdomain.
+func (site *Site) redirectAllowed(host, origin string) bool {
+	if len(site.AllowedHosts) == 0 {
+		return true
+	}
+
+	host = strings.ToLower(host)
+	if host == strings.ToLower(origin) {
+		return true
+	}
+
+	for _, allowed := range site.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+		if suffix, ok := strings.CutPrefix(allowed, "*"); ok {
+			if strings.HasSuffix(host, suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *Metrics) recordRedirects(site Site, resp *http.Response, rs *redirectState) {
+	if g, err := m.HttpRedirects.GetMetricWithLabelValues(m.values(site)...); err == nil {
+		g.Set(float64(rs.hops))
+	} else {
+		log.Println(err)
+	}
+
+	final := *resp.Request.URL
+	final.User = nil
+
+	// a site has one final URL, where its latest probe ended
+	m.HttpFinalURL.DeletePartialMatch(m.partial(site))
+	if g, err := m.HttpFinalURL.GetMetricWithLabelValues(m.values(site, final.String())...); err == nil {
+		g.Set(1)
+	} else {
+		log.Println(err)
+	}
+}
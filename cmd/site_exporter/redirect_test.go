@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// startRedirectServer serves /hop/N, which redirects to /hop/N-1 until
+// /hop/0 answers 200, and /away, which redirects to /hop/0 on localhost
+// instead of 127.0.0.1.
+func startRedirectServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/away" {
+			away := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+			http.Redirect(w, r, away+"/hop/0", http.StatusFound)
+			return
+		}
+
+		n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/hop/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if n > 0 {
+			http.Redirect(w, r, "/hop/"+strconv.Itoa(n-1), http.StatusFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestRedirects(t *testing.T) {
+	srv := startRedirectServer(t)
+
+	tests := []struct {
+		path         string
+		redirects    string
+		allowedHosts []string
+		hops         int
+		failed       []string // checks failed, in the order counted
+	}{
+		{"/hop/3", "", nil, 3, nil},
+		{"/hop/3", "3", nil, 3, nil},
+		{"/hop/3", "2", nil, 3, []string{checkRedirects}},
+		{"/hop/1", "none", nil, 1, []string{checkRedirects}},
+		{"/hop/0", "none", nil, 0, nil},
+		{"/away", "", []string{"example.com"}, 1, []string{checkRedirectHost}},
+		{"/away", "", []string{"localhost"}, 1, nil},
+		{"/away", "", []string{"*.example.com", "*host"}, 1, nil},
+	}
+
+	for _, tt := range tests {
+		sc := SiteConfig{EndPoint: srv.URL + tt.path, Redirects: tt.redirects, AllowedHosts: tt.allowedHosts}
+		site, errs := sc.Site(false)
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+
+		m := NewMetrics(nil)
+		m.probe(context.Background(), site)
+
+		name := tt.path + " redirects=" + tt.redirects + " allowed_hosts=" + strings.Join(tt.allowedHosts, ",")
+		if got := testutil.ToFloat64(m.HttpRedirects.WithLabelValues(site.Name)); got != float64(tt.hops) {
+			t.Errorf("%s: got %v hops, want %d", name, got, tt.hops)
+		}
+
+		var failed []string
+		for _, check := range []string{checkRedirects, checkRedirectHost, checkStatus} {
+			if n := testutil.ToFloat64(m.HttpCheckFailureTotal.WithLabelValues(site.Name, check)); n > 0 {
+				failed = append(failed, check)
+			}
+		}
+		if !slices.Equal(failed, tt.failed) {
+			t.Errorf("%s: got %q failed, want %q", name, failed, tt.failed)
+		}
+
+		want := 1.0
+		if len(tt.failed) > 0 {
+			want = 0
+		}
+		if got := testutil.ToFloat64(m.HttpProbeSuccess.WithLabelValues(site.Name)); got != want {
+			t.Errorf("%s: got success %v, want %v", name, got, want)
+		}
+	}
+}
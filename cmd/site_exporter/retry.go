@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// on value retrying a 5xx response, besides the failure reasons
+const retryStatus5xx = "5xx"
+
+// Retry defaults and limits
+const (
+	defaultRetryBackoff = 500 * time.Millisecond
+	maxRetryAttempts    = 10
+)
+
+// retried when a site names no reasons of its own
+var defaultRetryOn = []string{reasonRefused, reasonReset, reasonTimeout}
+
+// Retry is a site's retry policy. A zero Retry tries once.
+type Retry struct {
+	Attempts int           // tries in all, the first one included
+	Backoff  time.Duration // wait before the first retry, doubled after each
+	On       []string      // failure reasons, and 5xx, worth another try
+}
+
+// RetryConfig is the site list form of Retry.
+type RetryConfig struct {
+	Attempts int      `yaml:"attempts,omitempty" json:"attempts,omitempty"`
+	Backoff  string   `yaml:"backoff,omitempty" json:"backoff,omitempty"`
+	On       []string `yaml:"on,omitempty" json:"on,omitempty"`
+}
+
+// setOption applies a tab separated site list option. It reports false
+// when key is not a retry option.
+func (c *RetryConfig) setOption(key, value string) (bool, error) {
+	switch key {
+	case "retry_attempts":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return true, fmt.Errorf("invalid retry_attempts: %q", value)
+		}
+		c.Attempts = n
+	case "retry_backoff":
+		c.Backoff = value
+	case "retry_on":
+		c.On = strings.Split(value, ",")
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (c RetryConfig) compile() (Retry, []error) {
+	var errs []error
+	r := Retry{Attempts: c.Attempts, Backoff: defaultRetryBackoff, On: defaultRetryOn}
+
+	if c.Attempts < 0 || c.Attempts > maxRetryAttempts {
+		errs = append(errs, &fieldError{"retry.attempts", fmt.Errorf("must be between 0 and %d: %d", maxRetryAttempts, c.Attempts)})
+	}
+
+	if c.Backoff != "" {
+		d, err := time.ParseDuration(c.Backoff)
+		if err != nil || d < 0 {
+			errs = append(errs, &fieldError{"retry.backoff", fmt.Errorf("invalid duration: %q", c.Backoff)})
+		}
+		r.Backoff = d
+	}
+
+	if len(c.On) > 0 {
+		r.On = nil
+		for _, on := range c.On {
+			on = strings.ToLower(strings.TrimSpace(on))
+			switch on {
+			case reasonDNS, reasonRefused, reasonTimeout, reasonTLS, reasonReset, reasonOther, retryStatus5xx:
+				r.On = append(r.On, on)
+			default:
+				errs = append(errs, &fieldError{"retry.on", fmt.Errorf("unknown reason: %q", on)})
+			}
+		}
+	}
+
+	return r, errs
+}
+
+// Why a try failed and whether that is worth another try.
+func (r Retry) retryable(resp *http.Response, err error) (string, bool) {
+	if err != nil {
+		reason := failureReason(err)
+		return reason, slices.Contains(r.On, reason)
+	}
+
+	if resp.StatusCode >= 500 && slices.Contains(r.On, retryStatus5xx) {
+		return retryStatus5xx, true
+	}
+
+	return "", false
+}
+
+// The context of try n, counted from 1. When timeouts are retried the
+// tries left share what remains of the probe's deadline, so a try that
+// hangs leaves time for the next.
+func (r Retry) tryContext(ctx context.Context, n int) (context.Context, context.CancelFunc) {
+	left := r.Attempts - n + 1
+	deadline, ok := ctx.Deadline()
+	if left <= 1 || !ok || !slices.Contains(r.On, reasonTimeout) {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, time.Until(deadline)/time.Duration(left))
+}
+
+// Wait out the backoff after try n. It reports false when the probe ran
+// out of time first.
+func (r Retry) wait(ctx context.Context, n int) bool {
+	t := time.NewTimer(r.Backoff << (n - 1))
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+func (m *Metrics) retried(site Site, reason string) {
+	if c, err := m.HttpRequestRetryTotal.GetMetricWithLabelValues(m.values(site, reason)...); err == nil {
+		c.Inc()
+	} else {
+		log.Println(err)
+	}
+}
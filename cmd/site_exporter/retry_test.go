@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		retry     RetryConfig
+		failFirst int32 // tries answered 503 before a 200
+		tries     int32
+		retries   float64 // counted with reason 5xx
+		success   float64
+	}{
+		{"no retry", RetryConfig{}, 1, 1, 0, 0},
+		{"5xx not retried", RetryConfig{Attempts: 3, Backoff: "1ms"}, 1, 1, 0, 0},
+		{"5xx retried", RetryConfig{Attempts: 3, Backoff: "1ms", On: []string{"5xx"}}, 2, 3, 2, 1},
+		{"out of tries", RetryConfig{Attempts: 3, Backoff: "1ms", On: []string{"5xx"}}, 5, 3, 2, 0},
+		{"first try good", RetryConfig{Attempts: 3, Backoff: "1ms", On: []string{"5xx"}}, 0, 1, 0, 1},
+	}
+
+	for _, tt := range tests {
+		var tries atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tries.Add(1) <= tt.failFirst {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+
+		site, errs := SiteConfig{EndPoint: srv.URL, Retry: tt.retry}.Site(false)
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		m := NewMetrics(nil)
+		m.probe(context.Background(), site)
+		srv.Close()
+
+		if got := tries.Load(); got != tt.tries {
+			t.Errorf("%s: got %d tries, want %d", tt.name, got, tt.tries)
+		}
+		if got := testutil.ToFloat64(m.HttpRequestRetryTotal.WithLabelValues(site.Name, retryStatus5xx)); got != tt.retries {
+			t.Errorf("%s: got %v retries, want %v", tt.name, got, tt.retries)
+		}
+		if got := testutil.ToFloat64(m.HttpRequestAttemptsTotal.WithLabelValues(site.Name)); got != 1 {
+			t.Errorf("%s: got %v attempts, want 1 per probe", tt.name, got)
+		}
+		if got := testutil.ToFloat64(m.HttpProbeSuccess.WithLabelValues(site.Name)); got != tt.success {
+			t.Errorf("%s: got success %v, want %v", tt.name, got, tt.success)
+		}
+	}
+}
+
+func TestRetryRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := "http://" + l.Addr().String() + "/"
+	l.Close()
+
+	site, errs := SiteConfig{EndPoint: closed, Retry: RetryConfig{Attempts: 3, Backoff: "1ms"}}.Site(false)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	m := NewMetrics(nil)
+	m.probe(context.Background(), site)
+
+	if got := testutil.ToFloat64(m.HttpRequestRetryTotal.WithLabelValues(site.Name, reasonRefused)); got != 2 {
+		t.Errorf("got %v refused retries, want 2", got)
+	}
+	if got := testutil.ToFloat64(m.HttpRequestFailureTotal.WithLabelValues(site.Name, reasonRefused)); got != 1 {
+		t.Errorf("got %v refused failures, want 1, the last try", got)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Scheduler probes every site on its own interval in the background and
+// remembers when each site was last probed. At most concurrency probes are
+// in flight at any time.
+type Scheduler struct {
+	probe    func(context.Context, Site)
+	sem      chan struct{}
+	interval time.Duration
+	timeout  time.Duration
+
+	mu     sync.Mutex
+	last   map[string]time.Time
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewScheduler(probe func(context.Context, Site), concurrency int, interval, timeout time.Duration) *Scheduler {
+	return &Scheduler{
+		probe:    probe,
+		sem:      make(chan struct{}, concurrency),
+		interval: interval,
+		timeout:  timeout,
+		last:     make(map[string]time.Time),
+	}
+}
+
+// Start stops any running probe loops and starts one per site. Cached
+// results for sites that are still in the list are kept.
+func (s *Scheduler) Start(sites []Site) {
+	s.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	s.cancel = cancel
+	keep := make(map[string]time.Time)
+	for _, site := range sites {
+		if t, ok := s.last[site.key()]; ok {
+			keep[site.key()] = t
+		}
+	}
+	s.last = keep
+	s.mu.Unlock()
+
+	for _, site := range sites {
+		s.wg.Add(1)
+		go s.run(ctx, site)
+	}
+}
+
+// Stop cancels all probe loops and waits for in-flight probes to finish.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	cancel := s.cancel
+	s.cancel = nil
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		s.wg.Wait()
+	}
+}
+
+// LastProbe returns the time the site was last probed.
+func (s *Scheduler) LastProbe(site Site) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.last[site.key()]
+	return t, ok
+}
+
+func (s *Scheduler) run(ctx context.Context, site Site) {
+	defer s.wg.Done()
+
+	interval := site.Interval
+	if interval == 0 {
+		interval = s.interval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.once(ctx, site)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) once(ctx context.Context, site Site) {
+	select {
+	case s.sem <- struct{}{}:
+		defer func() { <-s.sem }()
+	case <-ctx.Done():
+		return
+	}
+
+	timeout := site.Timeout
+	if timeout == 0 {
+		timeout = s.timeout
+	}
+
+	pctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	s.probe(pctx, site)
+	if ctx.Err() != nil {
+		return // stopped mid-probe, the result is not worth caching
+	}
+
+	s.mu.Lock()
+	s.last[site.key()] = time.Now()
+	s.mu.Unlock()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Prefixes of a credential that is a reference to the secret rather than
+// the secret itself
+const (
+	secretEnv        = "env:"
+	secretFile       = "file:"
+	secretCredential = "credential:"
+)
+
+// Resolve a credential reference:
+//
+//	env:NAME          the environment variable NAME
+//	file:/path        the contents of a file, trailing newlines dropped
+//	credential:NAME   a systemd credential, see LoadCredential= in
+//	                  systemd.exec(5)
+//
+// Anything else is the secret itself. Errors name the reference and never
+// the secret, so they are safe to log.
+func resolveSecret(ref string) (string, error) {
+	if err := checkSecret(ref); err != nil {
+		return "", err
+	}
+
+	switch {
+	case strings.HasPrefix(ref, secretEnv):
+		name := ref[len(secretEnv):]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+	case strings.HasPrefix(ref, secretFile):
+		return readSecretFile(ref[len(secretFile):])
+	case strings.HasPrefix(ref, secretCredential):
+		name := ref[len(secretCredential):]
+		dir := os.Getenv("CREDENTIALS_DIRECTORY")
+		if dir == "" {
+			return "", fmt.Errorf("credential %s: CREDENTIALS_DIRECTORY is not set", name)
+		}
+		return readSecretFile(filepath.Join(dir, name))
+	}
+
+	return ref, nil
+}
+
+// Check the syntax of a credential reference without resolving it, for
+// -check-config, which may run where the secrets are not.
+func checkSecret(ref string) error {
+	switch {
+	case ref == secretEnv:
+		return errors.New("no environment variable named")
+	case ref == secretFile:
+		return errors.New("no file named")
+	case strings.HasPrefix(ref, secretCredential):
+		name := ref[len(secretCredential):]
+		if name == "" || strings.ContainsRune(name, '/') {
+			return fmt.Errorf("invalid credential name: %q", name)
+		}
+	}
+
+	return nil
+}
+
+// The os.PathError of a failed read carries only the path.
+func readSecretFile(path string) (string, error) {
+	if path == "" {
+		return "", errors.New("no file named")
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// Resolve the site's credentials. A password of @path is left to be read
+// on every probe instead. A failed reference is left in place, the site
+// is rejected anyway. With syntaxOnly the references are only checked.
+func (site *Site) resolveSecrets(syntaxOnly bool) []error {
+	var errs []error
+
+	resolveSecret := resolveSecret
+	if syntaxOnly {
+		resolveSecret = func(ref string) (string, error) { return ref, checkSecret(ref) }
+	}
+
+	if user, err := resolveSecret(site.User); err != nil {
+		errs = append(errs, &fieldError{"auth.user", err})
+	} else {
+		site.User = user
+	}
+
+	if path, ok := strings.CutPrefix(site.Password, "@"); ok {
+		site.passwordFile = path
+		return errs
+	}
+
+	if password, err := resolveSecret(site.Password); err != nil {
+		errs = append(errs, &fieldError{"auth.password", err})
+	} else {
+		site.Password = password
+	}
+
+	return errs
+}
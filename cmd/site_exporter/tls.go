@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// TLSOptions configure how a site's TLS connections are made.
+type TLSOptions struct {
+	CertFile           string `yaml:"cert,omitempty" json:"cert,omitempty"`
+	KeyFile            string `yaml:"key,omitempty" json:"key,omitempty"`
+	CAFile             string `yaml:"ca,omitempty" json:"ca,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty" json:"insecure_skip_verify,omitempty"`
+	ServerName         string `yaml:"server_name,omitempty" json:"server_name,omitempty"`
+}
+
+// setOption applies a tab separated site list option. It reports false
+// when key is not a TLS option.
+func (t *TLSOptions) setOption(key, value string) (bool, error) {
+	switch key {
+	case "cert":
+		t.CertFile = value
+	case "key":
+		t.KeyFile = value
+	case "ca":
+		t.CAFile = value
+	case "insecure_skip_verify":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return true, fmt.Errorf("invalid insecure_skip_verify: %q", value)
+		}
+		t.InsecureSkipVerify = b
+	case "server_name":
+		t.ServerName = value
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Check the options without reading the files they name.
+func (t TLSOptions) check() error {
+	if t.CertFile != "" && t.KeyFile == "" {
+		return &fieldError{"tls.cert", errors.New("cert needs a key")}
+	}
+	if t.KeyFile != "" && t.CertFile == "" {
+		return &fieldError{"tls.key", errors.New("key needs a cert")}
+	}
+
+	return nil
+}
+
+// Build a tls.Config from the options. Certificate files are read now, so
+// changes need a site list reload.
+func (t TLSOptions) config() (*tls.Config, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		InsecureSkipVerify: t.InsecureSkipVerify,
+		ServerName:         t.ServerName,
+	}
+
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, &fieldError{"tls.cert", err}
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, &fieldError{"tls.ca", err}
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, &fieldError{"tls.ca", fmt.Errorf("no certificates found in %s", t.CAFile)}
+		}
+		cfg.RootCAs = pool
+	}
+
+	return cfg, nil
+}
+
+// Give the site its own http.Client carrying its TLS, proxy, dialer and
+// protocol settings. The oauth2 token client shares them, except for the
+// SNI override and protocol which only apply to the probed endpoint.
+func (site *Site) initClient() error {
+	cfg, err := site.TLS.config()
+	if err != nil {
+		return err
+	}
+
+	// every probe opens a fresh connection so DNS, connect and TLS timings
+	// are measured each time instead of hidden by a pooled connection
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	transport.DisableKeepAlives = true
+	transport.DialContext = site.dialContext
+	if site.Proxy == proxyDirect {
+		transport.Proxy = nil
+	} else if site.Proxy != "" {
+		u, err := url.Parse(site.Proxy)
+		if err != nil {
+			return &fieldError{"proxy", err}
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	site.setProtocols(transport)
+
+	var rt http.RoundTripper = transport
+	if site.Protocol == protocolHTTP3 {
+		rt = site.http3Transport(cfg)
+	}
+	site.client = &http.Client{
+		Transport:     rt,
+		CheckRedirect: site.checkRedirect,
+	}
+
+	if site.AuthType == authOAuth2 {
+		tokenCfg := cfg.Clone()
+		tokenCfg.ServerName = ""
+		tokenTransport := http.DefaultTransport.(*http.Transport).Clone()
+		tokenTransport.TLSClientConfig = tokenCfg
+		tokenTransport.DialContext = transport.DialContext
+		tokenTransport.Proxy = transport.Proxy
+		site.tokenClient = &http.Client{Transport: tokenTransport}
+	}
+
+	return nil
+}
+
+// Export certificate expiry and handshake details of a probe made over TLS.
+func (m *Metrics) recordTLS(site Site, resp *http.Response) {
+	state := resp.TLS
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return
+	}
+
+	leaf := state.PeerCertificates[0]
+	if g, err := m.TLSCertNotAfter.GetMetricWithLabelValues(m.values(site)...); err == nil {
+		g.Set(float64(leaf.NotAfter.Unix()))
+	} else {
+		log.Println(err)
+	}
+
+	earliest := leaf.NotAfter
+	for _, cert := range state.PeerCertificates[1:] {
+		if cert.NotAfter.Before(earliest) {
+			earliest = cert.NotAfter
+		}
+	}
+	if g, err := m.TLSChainNotAfter.GetMetricWithLabelValues(m.values(site)...); err == nil {
+		g.Set(float64(earliest.Unix()))
+	} else {
+		log.Println(err)
+	}
+
+	// drop the previous handshake so a changed version or cipher does not
+	// leave a stale series behind
+	m.TLSInfo.DeletePartialMatch(m.partial(site))
+	version := tls.VersionName(state.Version)
+	cipher := tls.CipherSuiteName(state.CipherSuite)
+	if g, err := m.TLSInfo.GetMetricWithLabelValues(m.values(site, version, cipher)...); err == nil {
+		g.Set(1)
+	} else {
+		log.Println(err)
+	}
+
+	name := site.TLS.ServerName
+	if name == "" {
+		name = resp.Request.URL.Hostname()
+	}
+	verified := 0.0
+	if leaf.VerifyHostname(name) == nil {
+		verified = 1
+	}
+	if g, err := m.TLSHostnameVerified.GetMetricWithLabelValues(m.values(site)...); err == nil {
+		g.Set(verified)
+	} else {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Probe phases used for the phase label
+const (
+	phaseDNS        = "dns"
+	phaseConnect    = "connect"
+	phaseTLS        = "tls"
+	phaseProcessing = "processing"
+	phaseTransfer   = "transfer"
+)
+
+var phases = []string{phaseDNS, phaseConnect, phaseTLS, phaseProcessing, phaseTransfer}
+
+// phaseTimer collects per-phase timings from httptrace hooks. When a probe
+// follows redirects the time spent in each phase is summed over the hops.
+// Hooks can fire from several goroutines while dialing, hence the lock.
+type phaseTimer struct {
+	mu           sync.Mutex
+	dnsStart     time.Time
+	connectStart map[string]time.Time
+	tlsStart     time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	remote       string // address of the last connection made
+	durations    map[string]time.Duration
+}
+
+func newPhaseTimer() *phaseTimer {
+	return &phaseTimer{
+		connectStart: make(map[string]time.Time),
+		durations:    make(map[string]time.Duration),
+	}
+}
+
+func (pt *phaseTimer) add(phase string, since time.Time) {
+	if since.IsZero() {
+		return
+	}
+	pt.durations[phase] += time.Since(since)
+}
+
+func (pt *phaseTimer) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			pt.mu.Lock()
+			defer pt.mu.Unlock()
+			pt.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			pt.mu.Lock()
+			defer pt.mu.Unlock()
+			pt.add(phaseDNS, pt.dnsStart)
+		},
+		ConnectStart: func(network, addr string) {
+			pt.mu.Lock()
+			defer pt.mu.Unlock()
+			pt.connectStart[network+addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			pt.mu.Lock()
+			defer pt.mu.Unlock()
+			// only the dial that won counts, happy eyeballs may race several
+			if err == nil {
+				pt.add(phaseConnect, pt.connectStart[network+addr])
+				pt.remote = addr
+			}
+		},
+		TLSHandshakeStart: func() {
+			pt.mu.Lock()
+			defer pt.mu.Unlock()
+			pt.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			pt.mu.Lock()
+			defer pt.mu.Unlock()
+			pt.add(phaseTLS, pt.tlsStart)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			pt.mu.Lock()
+			defer pt.mu.Unlock()
+			pt.wroteRequest = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			pt.mu.Lock()
+			defer pt.mu.Unlock()
+			pt.add(phaseProcessing, pt.wroteRequest)
+			pt.firstByte = time.Now()
+		},
+	}
+}
+
+// Mark the end of the body transfer of the final response.
+func (pt *phaseTimer) done() {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.add(phaseTransfer, pt.firstByte)
+}
+
+func (pt *phaseTimer) remoteAddr() string {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	return pt.remote
+}
+
+func (pt *phaseTimer) seconds(phase string) float64 {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	return pt.durations[phase].Seconds()
+}
+
+func (m *Metrics) recordPhases(site Site, pt *phaseTimer) {
+	for _, phase := range phases {
+		d := pt.seconds(phase)
+
+		if g, err := m.HttpPhaseDuration.GetMetricWithLabelValues(m.values(site, phase)...); err == nil {
+			g.Set(d)
+		} else {
+			log.Println(err)
+		}
+
+		if h, err := m.HttpPhaseDurationBucket.GetMetricWithLabelValues(m.values(site, phase)...); err == nil {
+			h.Observe(d)
+		} else {
+			log.Println(err)
+		}
+	}
+}
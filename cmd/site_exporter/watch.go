@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// changes closer together than this are handled as one reload
+const watchSettle = time.Second
+
+// Watch the site list and reload it when its content changes. The watch
+// is on the directory, not the file, because editors and config management
+// usually replace the file by rename, which would end a watch on the file
+// itself. Symlinked lists, as in a mounted ConfigMap, are covered as well
+// since any change in the directory leads to comparing the content.
+func (ssc *SiteStatCollector) Watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	if err := watcher.Add(filepath.Dir(ssc.siteListFile)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	last := fileHash(ssc.siteListFile)
+	go func() {
+		defer watcher.Close()
+
+		settle := time.NewTimer(watchSettle)
+		settle.Stop()
+
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				settle.Reset(watchSettle)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("site list watch: %v\n", err)
+			case <-settle.C:
+				sum := fileHash(ssc.siteListFile)
+				if sum == last {
+					continue
+				}
+				// remember even a failed attempt, the same broken content
+				// is not worth rereading on every unrelated event
+				last = sum
+				log.Printf("site list changed: %s\n", ssc.siteListFile)
+				ssc.Reload()
+			}
+		}
+	}()
+
+	log.Printf("watching site list: %s\n", ssc.siteListFile)
+	return nil
+}
+
+// fileHash returns the zero sum when the file cannot be read.
+func fileHash(fileName string) [sha256.Size]byte {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return [sha256.Size]byte{}
+	}
+	return sha256.Sum256(b)
+}